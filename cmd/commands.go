@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigredeye/concurrency_watcher/internal/config"
+	"github.com/bigredeye/concurrency_watcher/internal/history"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+const usage = `Usage: concurrency_watcher [command] [flags]
+
+Commands:
+  daemon              Sync periodically until interrupted (default), the
+                      config is reloaded on change and on SIGHUP
+  sync [--once]       Sync once and exit with a non-zero status on failure
+  sync --dry-run      Render sheets to --out as CSV and HTML, diff against
+                      the spreadsheet when credentials allow reading it
+  validate            Check config, credentials, deadlines and reviewers,
+                      reporting every problem instead of the first one
+  tasks [--course c]  Print the parsed deadline schedule
+  config print        Show the effective config with secrets redacted and
+                      where each value came from
+  explain <mr-url>    Show how a merge request is parsed and classified,
+                      --course selects the course if the url is ambiguous
+
+Secrets (GITLAB_TOKEN, NOTIFY_TELEGRAM_TOKEN, NOTIFY_SMTP_PASSWORD) can also
+be read from the file named in <NAME>_FILE or from the output of the shell
+command in <NAME>_COMMAND.
+
+Logging is configured with LOG_LEVEL, LOG_FORMAT (text or json) and
+LOG_FILE, rotated according to LOG_FILE_MAX_SIZE_MB and LOG_FILE_MAX_BACKUPS.
+
+Missing tabs are created unless SHEETS_CREATE_MISSING is false, with a frozen
+(SHEETS_FREEZE_HEADER) and optionally protected (SHEETS_PROTECT_HEADER) header.
+The sheet_layout config section sets position and tab_color per sheet.
+`
+
+func runCommand(conf *config.Config, args []string) error {
+	name := "daemon"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "daemon":
+		return runDaemon(conf, args)
+	case "sync":
+		return runSync(conf, args)
+	case "validate":
+		return runValidate(conf, args)
+	case "tasks":
+		return runTasks(conf, args)
+	case "explain":
+		return runExplain(conf, args)
+	case "config":
+		return runConfig(conf, args)
+	case "help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("Unknown command %q", name)
+	}
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func runDaemon(conf *config.Config, args []string) error {
+	if err := newFlagSet("daemon").Parse(args); err != nil {
+		return err
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	daemons, err := newDaemons(conf)
+	defer closeDaemons(daemons)
+	if err != nil {
+		if len(daemons) == 0 {
+			return err
+		}
+		log.WithError(err).Errorln("Starting with the remaining courses")
+	}
+
+	for _, daemon := range daemons {
+		if err := daemon.reviewers.Watch(); err != nil {
+			daemon.logger.WithError(err).Warnln("Failed to watch reviewers file, changes will require a restart")
+		}
+	}
+
+	server := metrics.NewServer(conf.MetricsAddress)
+	readiness(server, daemons)
+	server.Start()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	reloader := newReloader(conf, daemons)
+	watcher, err := config.Watch(func() {
+		reloader.reload("config file change")
+	})
+	if err != nil {
+		log.WithError(err).Warnln("Failed to watch config file, send SIGHUP to reload it")
+	} else {
+		defer watcher.Close()
+	}
+
+	return runLoops(daemons, func() {
+		reloader.reload("SIGHUP")
+	})
+}
+
+func runSync(conf *config.Config, args []string) error {
+	flags := newFlagSet("sync")
+	once := flags.Bool("once", false, "run a single iteration and exit")
+	dryRun := flags.Bool("dry-run", false, "render sheets locally instead of writing them, implies --once")
+	out := flags.String("out", "dry-run", "directory for dry run output, with a subdirectory per course if there are several")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !*once && !*dryRun {
+		return runDaemon(conf, flags.Args())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *dryRun {
+		return runDryRun(ctx, conf, *out)
+	}
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	daemons, err := newDaemons(conf)
+	defer closeDaemons(daemons)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(daemons))
+	var wg sync.WaitGroup
+	for i, daemon := range daemons {
+		wg.Add(1)
+		go func(i int, daemon *Daemon) {
+			defer wg.Done()
+			errs[i] = runSafely(daemon.iterationContext(ctx), daemon.sync)
+		}(i, daemon)
+	}
+	wg.Wait()
+
+	if err := courseErrors(daemons, errs); err != nil {
+		return fmt.Errorf("Sync failed: %w", err)
+	}
+	log.Infoln("Sync finished")
+	return nil
+}
+
+func runDryRun(ctx context.Context, conf *config.Config, out string) error {
+	courses := conf.CourseConfigs()
+	for _, course := range courses {
+		dir := out
+		if len(courses) > 1 {
+			dir = filepath.Join(out, course.CourseName)
+		}
+
+		daemon, renderer, err := newDryRunDaemon(course, dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", course.CourseName, err)
+		}
+		err = daemon.sync(daemon.iterationContext(ctx))
+		daemon.Close()
+		if err != nil {
+			return fmt.Errorf("Dry run of %s failed: %w", course.CourseName, err)
+		}
+		if err := renderer.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectCourse returns the course with the given name. An empty name
+// selects the only course, or the one for whose GitLab group project is a
+// subgroup or project if project is set.
+func selectCourse(conf *config.Config, name string, project string) (*config.Config, error) {
+	courses := conf.CourseConfigs()
+	names := make([]string, 0, len(courses))
+	for _, course := range courses {
+		names = append(names, course.CourseName)
+		if course.CourseName == name {
+			return course, nil
+		}
+	}
+	if name != "" {
+		return nil, fmt.Errorf("Unknown course %q, configured courses are %s", name, strings.Join(names, ", "))
+	}
+
+	if len(courses) == 1 {
+		return courses[0], nil
+	}
+	if project != "" {
+		for _, course := range courses {
+			if strings.HasPrefix(project, course.GitLabGroup+"/") {
+				return course, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Several courses are configured, pass --course with one of %s", strings.Join(names, ", "))
+}
+
+func runValidate(conf *config.Config, args []string) error {
+	if err := newFlagSet("validate").Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	failed := 0
+	if err := conf.Validate(); err != nil {
+		var invalid *config.ValidationError
+		if !errors.As(err, &invalid) {
+			return err
+		}
+		for _, problem := range invalid.Problems {
+			fmt.Printf("FAIL  %-24s %s\n", "config", problem)
+		}
+		failed += len(invalid.Problems)
+	} else {
+		fmt.Printf("OK    %-24s %s\n", "config", "no problems found")
+	}
+
+	courses := conf.CourseConfigs()
+	for _, course := range courses {
+		failed += validateCourse(ctx, course, len(courses) > 1)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// validateCourse prints a line per check of the course and returns the
+// number of failed checks.
+func validateCourse(ctx context.Context, conf *config.Config, prefixed bool) int {
+	failed := 0
+	check := func(name string, fn func() (string, error)) {
+		if prefixed {
+			name = conf.CourseName + "/" + name
+		}
+		details, err := fn()
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %-24s %v\n", name, err)
+			return
+		}
+		fmt.Printf("OK    %-24s %s\n", name, details)
+	}
+
+	check("sheets", func() (string, error) {
+		client, err := sheets.NewClient(ctx, conf.GoogleCredentialsPath)
+		if err != nil {
+			return "", err
+		}
+		titles, err := client.ListSheets(ctx, conf.GoogleSpreadsheetId)
+		if err != nil {
+			return "", err
+		}
+
+		expected, err := generatedSheets(conf)
+		if err != nil {
+			return "", err
+		}
+
+		existing := make(map[string]bool)
+		for _, title := range titles {
+			existing[title] = true
+		}
+		missing := make([]string, 0)
+		for _, sheet := range expected {
+			if !existing[sheet] {
+				missing = append(missing, sheet)
+			}
+		}
+		if len(missing) > 0 && !conf.SheetsCreateMissing {
+			return "", fmt.Errorf("missing tabs %s", strings.Join(missing, ", "))
+		}
+		if len(missing) > 0 {
+			return fmt.Sprintf("spreadsheet has %d tabs, %s will be created", len(titles), strings.Join(missing, ", ")), nil
+		}
+		return fmt.Sprintf("spreadsheet has %d tabs", len(titles)), nil
+	})
+
+	check("gitlab", func() (string, error) {
+		client, err := newGitLabClient(conf)
+		if err != nil {
+			return "", err
+		}
+		user, err := client.CurrentUser(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "authenticated as @" + user.Username, nil
+	})
+
+	check("deadlines", func() (string, error) {
+		deadlines, err := fetchDeadlines(ctx, conf.DeadlinesUrl)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d groups, %d tasks", len(deadlines), len(listTasks(deadlines))), nil
+	})
+
+	check("reviewers", func() (string, error) {
+		list, err := reviewers.Load(conf.ReviewersPath)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d reviewers", list.Len()), nil
+	})
+
+	return failed
+}
+
+func runTasks(conf *config.Config, args []string) error {
+	flags := newFlagSet("tasks")
+	courseName := flags.String("course", "", "print tasks of a single course")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	courses := conf.CourseConfigs()
+	if *courseName != "" {
+		course, err := selectCourse(conf, *courseName, "")
+		if err != nil {
+			return err
+		}
+		courses = []*config.Config{course}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COURSE\tGROUP\tSTART\tDEADLINE\tTASK\tSCORE")
+	for _, course := range courses {
+		deadlines, err := fetchDeadlines(context.Background(), course.DeadlinesUrl)
+		if err != nil {
+			return fmt.Errorf("Failed to get tasks of %s from deadlines.yml: %w", course.CourseName, err)
+		}
+
+		for _, group := range deadlines {
+			for _, task := range group.Tasks {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", course.CourseName, group.Group, group.Start, group.Deadline, task.Task, task.Score)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+func runConfig(conf *config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("config expects the print subcommand")
+	}
+	if err := newFlagSet("config print").Parse(args[1:]); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, entry := range conf.Entries() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Key, strings.ReplaceAll(entry.Value, "\n", "\\n"), entry.Source)
+	}
+	return w.Flush()
+}
+
+// parseMergeRequestUrl splits a merge request web url such as
+// https://gitlab.com/group/project/-/merge_requests/42 into the project
+// path and the merge request iid.
+func parseMergeRequestUrl(rawUrl string) (string, string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(u.Path, "/-/merge_requests/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("%q is not a merge request url", rawUrl)
+	}
+
+	project := strings.Trim(parts[0], "/")
+	iid := strings.SplitN(parts[1], "/", 2)[0]
+	if project == "" || iid == "" {
+		return "", "", fmt.Errorf("%q is not a merge request url", rawUrl)
+	}
+	return project, iid, nil
+}
+
+func runExplain(conf *config.Config, args []string) error {
+	flags := newFlagSet("explain")
+	courseName := flags.String("course", "", "course of the merge request, guessed from the url by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("explain expects exactly one merge request url")
+	}
+
+	project, iid, err := parseMergeRequestUrl(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	conf, err = selectCourse(conf, *courseName, project)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client, err := newGitLabClient(conf)
+	if err != nil {
+		return err
+	}
+	mr, err := client.GetMergeRequest(ctx, project, iid)
+	if err != nil {
+		return err
+	}
+
+	list, err := reviewers.Load(conf.ReviewersPath)
+	if err != nil {
+		return err
+	}
+
+	knownTasks := make(map[string]bool)
+	deadlines, err := fetchDeadlines(ctx, conf.DeadlinesUrl)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to get tasks from deadlines.yml")
+	}
+	for _, task := range listTasks(deadlines) {
+		knownTasks[task] = true
+	}
+
+	parser := newMergeRequestTitleParser(list)
+	info := parser.parse(ctx, mr)
+	turnaround := newTurnaroundTracker(conf, time.Now())
+	text, color := turnaround.classify(info)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Course:\t%s\n", conf.CourseName)
+	fmt.Fprintf(w, "Url:\t%s\n", mr.WebUrl)
+	fmt.Fprintf(w, "Title:\t%s\n", mr.Title)
+	fmt.Fprintf(w, "Author:\t@%s\n", mr.Author.Username)
+	if parser.re.MatchString(mr.Title) {
+		fmt.Fprintf(w, "Parsed title:\tuniversity=%s student=%q task=%s\n", info.unversity, info.student, info.task)
+	} else {
+		fmt.Fprintf(w, "Parsed title:\tdoes not match %s, using author and full title\n", parser.re)
+	}
+	fmt.Fprintf(w, "Known task:\t%t\n", knownTasks[info.task])
+	fmt.Fprintf(w, "Pipeline:\t%s\n", info.pipelineStatus)
+	fmt.Fprintf(w, "Merge status:\t%s (conflicts: %t, should be rebased: %t)\n", info.mergeStatus, info.hasConflicts, info.shouldBeRebased)
+
+	approvedBy := make([]string, 0)
+	for _, reviewer := range info.approvedBy {
+		approvedBy = append(approvedBy, fmt.Sprintf("%s (%s)", reviewer.Username, reviewer.Pseudonym))
+	}
+	fmt.Fprintf(w, "Approved by:\t%s\n", strings.Join(approvedBy, ", "))
+	fmt.Fprintf(w, "Threads:\t%d resolvable, %d resolved\n", info.numProblems, info.numResolvedProblems)
+	fmt.Fprintf(w, "Status:\t%s\n", info.status())
+	if since, waiting := info.waitingSince(); waiting {
+		fmt.Fprintf(w, "Waiting since:\t%s (%s)\n", since.Format(time.RFC3339), formatAge(turnaround.now.Sub(since)))
+	}
+	fmt.Fprintf(w, "Cell:\t%q, background %s\n", text, formatHexColor(color))
+	if note := describeMergeProblems(info); note != "" {
+		fmt.Fprintf(w, "Note:\t%s\n", strings.ReplaceAll(note, "\n", "; "))
+	}
+
+	if conf.HistoryPath != "" {
+		store, err := history.Open(conf.HistoryPath)
+		if err != nil {
+			log.WithError(err).Warnln("Failed to open history store, is the daemon running?")
+		} else {
+			defer store.Close()
+			transitions, err := store.Transitions(mr.WebUrl)
+			if err != nil {
+				return err
+			}
+			for _, transition := range transitions {
+				from := transition.From
+				if from == "" {
+					from = "(first seen)"
+				}
+				fmt.Fprintf(w, "History:\t%s %s -> %s\n", transition.At.Format(time.RFC3339), from, transition.To)
+			}
+		}
+	}
+	return w.Flush()
+}
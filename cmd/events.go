@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bigredeye/concurrency_watcher/internal/history"
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+const (
+	sheetEvents        = "Events"
+	sheetEventsArchive = "Events archive"
+)
+
+type rotator interface {
+	Rotate(ctx context.Context, sheet string, archive string, maxRows int) error
+}
+
+// appendEvents appends status transitions to the Events sheet and moves
+// the oldest rows to the archive once the sheet grows above the limit.
+// The error is only returned when the transitions were not appended.
+func (d *Daemon) appendEvents(ctx context.Context, transitions []*history.Transition, mergeRequestsByStudent map[string][]*mergeRequestTitle, list *reviewers.List) error {
+	if len(transitions) == 0 {
+		return nil
+	}
+	logger := logging.FromContext(ctx)
+
+	byUrl := make(map[string]*mergeRequestTitle)
+	for _, mrs := range mergeRequestsByStudent {
+		for _, mr := range mrs {
+			byUrl[mr.url] = mr
+		}
+	}
+
+	events := sheets.NewTable("Timestamp", "Student", "Task", "Old status", "New status", "Url", "Actor")
+	for _, transition := range transitions {
+		actor := ""
+		if mr, found := byUrl[transition.Url]; found {
+			actor = mr.actor(transition.To, list)
+		}
+		events.Append(transition.At, transition.Student, transition.Task, transition.From, transition.To, transition.Url, actor)
+	}
+
+	if err := d.writer.AppendTable(ctx, sheetEvents, events); err != nil {
+		logger.WithError(err).Warnf("Failed to append to %s table", sheetEvents)
+		return err
+	}
+	logger.Infof("Appended %d rows to %s table", len(events.Rows), sheetEvents)
+
+	// The events are in the sheet at this point, so a failed rotation is
+	// not an error of the append: it is retried after the next one.
+	if r, ok := d.writer.(rotator); ok && d.config.EventsMaxRows > 0 {
+		if err := r.Rotate(ctx, sheetEvents, sheetEventsArchive, d.config.EventsMaxRows); err != nil {
+			logger.WithError(err).Warnf("Failed to rotate %s table", sheetEvents)
+		}
+	}
+	return nil
+}
+
+// actor guesses who caused the merge request to enter the status: the
+// approving or rejecting reviewer, or the author for everything else.
+func (mr *mergeRequestTitle) actor(status string, list *reviewers.List) string {
+	name := func(username string) string {
+		if reviewer, found := list.Lookup(username); found {
+			return reviewer.Pseudonym
+		}
+		return "@" + username
+	}
+
+	switch status {
+	case statusApproved:
+		var last *reviewEvent
+		for _, approval := range mr.approvals {
+			if last == nil || approval.at.After(last.at) {
+				last = approval
+			}
+		}
+		if last != nil {
+			return name(last.username)
+		}
+		if len(mr.approvedBy) > 0 {
+			return mr.approvedBy[len(mr.approvedBy)-1].Pseudonym
+		}
+		return ""
+	case statusRejected:
+		var last *reviewThread
+		for _, thread := range mr.threads {
+			if !thread.resolved && (last == nil || thread.openedAt.After(last.openedAt)) {
+				last = thread
+			}
+		}
+		if last != nil {
+			return name(last.openedBy)
+		}
+		return ""
+	case statusProblemsResolved, statusPending, statusNeedsRebase:
+		return "@" + mr.author
+	default:
+		return ""
+	}
+}
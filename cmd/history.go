@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"github.com/bigredeye/concurrency_watcher/internal/history"
+)
+
+func (mr *mergeRequestTitle) snapshot(now time.Time) *history.Snapshot {
+	approvedBy := make([]string, 0, len(mr.approvedBy))
+	for _, reviewer := range mr.approvedBy {
+		approvedBy = append(approvedBy, reviewer.Username)
+	}
+
+	return &history.Snapshot{
+		Url:             mr.url,
+		Student:         mr.student,
+		Task:            mr.task,
+		Title:           mr.title,
+		Status:          mr.status(),
+		PipelineStatus:  mr.pipelineStatus,
+		MergeStatus:     mr.mergeStatus,
+		ApprovedBy:      approvedBy,
+		Threads:         mr.numProblems,
+		ResolvedThreads: mr.numResolvedProblems,
+		ObservedAt:      now,
+	}
+}
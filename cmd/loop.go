@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+)
+
+// runLoops runs every course on its own schedule until SIGINT or SIGTERM
+// is received. SIGHUP calls onHangup and makes every course resync
+// immediately. A failing course does not affect the others; the returned
+// error lists courses whose last iteration failed.
+func runLoops(daemons []*Daemon, onHangup func()) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	shutdown := make(chan struct{})
+	resyncs := make([]chan struct{}, len(daemons))
+	for i := range resyncs {
+		resyncs[i] = make(chan struct{}, 1)
+	}
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				onHangup()
+				for _, resync := range resyncs {
+					select {
+					case resync <- struct{}{}:
+					default:
+					}
+				}
+				continue
+			}
+
+			log.Infof("Received %s, shutting down", sig)
+			close(shutdown)
+			return
+		}
+	}()
+
+	errs := make([]error, len(daemons))
+	var wg sync.WaitGroup
+	for i, daemon := range daemons {
+		wg.Add(1)
+		go func(i int, daemon *Daemon) {
+			defer wg.Done()
+			errs[i] = daemon.loop(daemon.sync, shutdown, resyncs[i])
+		}(i, daemon)
+	}
+	wg.Wait()
+
+	return courseErrors(daemons, errs)
+}
+
+// courseErrors combines per-course errors, errs[i] belonging to daemons[i].
+func courseErrors(daemons []*Daemon, errs []error) error {
+	failed := make([]string, 0)
+	var last error
+	for i, err := range errs {
+		if err != nil {
+			last = fmt.Errorf("%s: %w", daemons[i].config.CourseName, err)
+			failed = append(failed, last.Error())
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return last
+	default:
+		return errors.New("Courses failed: " + strings.Join(failed, "; "))
+	}
+}
+
+// readiness calls server.SetReady once every daemon has reported a
+// successful iteration.
+func readiness(server *metrics.Server, daemons []*Daemon) {
+	pending := int32(len(daemons))
+	for _, daemon := range daemons {
+		var once sync.Once
+		daemon.ready = func() {
+			once.Do(func() {
+				if atomic.AddInt32(&pending, -1) == 0 {
+					server.SetReady()
+				}
+			})
+		}
+	}
+}
+
+// loop runs iterations until shutdown is closed. It returns the error of
+// the last finished iteration, so the exit status reflects whether the
+// final sync succeeded.
+func (d *Daemon) loop(runIter func(context.Context) error, shutdown <-chan struct{}, resync <-chan struct{}) error {
+	for {
+		select {
+		case next := <-d.configs:
+			d.reconfigure(next)
+		default:
+		}
+
+		err := d.iterate(runIter, shutdown)
+		if err != nil {
+			d.logger.WithError(err).Warn("Iteration failed")
+		}
+
+		select {
+		case <-shutdown:
+			return err
+		default:
+		}
+
+		timer := time.NewTimer(d.config.IterationInterval)
+		select {
+		case <-shutdown:
+			timer.Stop()
+			return err
+		case <-resync:
+			timer.Stop()
+			d.logger.Infoln("Received SIGHUP, resyncing")
+		case next := <-d.configs:
+			timer.Stop()
+			d.reconfigure(next)
+		case <-timer.C:
+		}
+	}
+}
+
+// iterate runs a single iteration. If shutdown is requested meanwhile, the
+// iteration is given the configured grace period to finish before its
+// context is cancelled, which makes pending sheet snapshots roll back.
+func (d *Daemon) iterate(runIter func(context.Context) error, shutdown <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(d.iterationContext(context.Background()))
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		start := time.Now()
+		err := runSafely(ctx, runIter)
+		metrics.ObserveIteration(d.course, start, err)
+		if err == nil && d.ready != nil {
+			d.ready()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-shutdown:
+	}
+
+	d.logger.Infof("Waiting up to %s for the current iteration to finish", d.config.ShutdownGracePeriod)
+	timer := time.NewTimer(d.config.ShutdownGracePeriod)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		d.logger.Warnln("Grace period expired, cancelling the current iteration")
+		cancel()
+		return <-done
+	}
+}
+
+// iterationContext tags ctx with the course for metrics and with a logger
+// carrying the course and a new iteration id.
+func (d *Daemon) iterationContext(ctx context.Context) context.Context {
+	ctx = metrics.WithCourse(ctx, d.course)
+	return logging.WithLogger(ctx, d.logger.WithField("iteration", logging.NewIterationId()))
+}
+
+// runSafely turns a panic in the iteration into an error, so that a bug
+// triggered by one course does not bring down the others.
+func runSafely(ctx context.Context, runIter func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Iteration panicked: %v", r)
+		}
+	}()
+	return runIter(ctx)
+}
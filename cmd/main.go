@@ -2,14 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
 	"os"
 	"regexp"
-	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -18,43 +19,163 @@ import (
 
 	"github.com/bigredeye/concurrency_watcher/internal/config"
 	"github.com/bigredeye/concurrency_watcher/internal/gitlab"
+	"github.com/bigredeye/concurrency_watcher/internal/history"
 	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/preview"
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
 	"github.com/bigredeye/concurrency_watcher/internal/sheets"
 	"github.com/bigredeye/concurrency_watcher/internal/types"
 )
 
 func main() {
-	if err := run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		log.WithError(err).Fatalln("Process failed")
 	}
 }
 
 type Daemon struct {
-	config *config.Config
-	gitlab *gitlab.Client
-	sheets *sheets.Client
+	// course is config.CourseName. It never changes, so unlike config it
+	// can be read outside of the daemon goroutine.
+	course    string
+	config    *config.Config
+	gitlab    *gitlab.Client
+	sheets    *sheets.Client
+	writer    sheets.Writer
+	reviewers *reviewers.Source
+	history   *history.Store
+	logger    *log.Entry
+	// ready is called after every successful iteration.
+	ready func()
+	// configs delivers reloaded configs, applied between iterations.
+	configs chan *config.Config
+
+	notifications    *notifications
+	previousStatuses map[string]string
+	// lastSweep is when stale snapshot sheets were last looked for.
+	lastSweep time.Time
 }
 
 func newDaemon(conf *config.Config) (*Daemon, error) {
-	gitlabClient, err := gitlab.NewClient("https://gitlab.com", conf.GitLabToken)
+	daemon, err := newDaemonWithoutSheets(conf)
 	if err != nil {
-		log.WithError(err).Errorln("Failed to initialize gitlab client")
 		return nil, err
 	}
 
 	googleClient, err := sheets.NewClient(context.Background(), conf.GoogleCredentialsPath)
 	if err != nil {
-		log.WithError(err).Errorln("Failed to initialize google client")
+		daemon.logger.WithError(err).Errorln("Failed to initialize google client")
 		return nil, err
 	}
 
+	daemon.sheets = googleClient.CreateMissing(sheetOptions(conf))
+	daemon.writer = googleClient.Spreadsheet(conf.GoogleSpreadsheetId).UpdateMode(conf.SheetsUpdateMode)
+
+	if conf.HistoryPath != "" {
+		daemon.history, err = history.Open(conf.HistoryPath)
+		if err != nil {
+			daemon.logger.WithError(err).Errorln("Failed to open history store")
+			return nil, err
+		}
+	}
+
+	daemon.notifications, err = newNotifications(conf)
+	if err != nil {
+		daemon.logger.WithError(err).Errorln("Failed to initialize notifications")
+		daemon.Close()
+		return nil, err
+	}
+	return daemon, nil
+}
+
+func (d *Daemon) Close() {
+	d.reviewers.Close()
+	if d.history != nil {
+		d.history.Close()
+	}
+}
+
+// newDryRunDaemon renders sheets into dir instead of writing them. Google
+// credentials are optional and only used to diff against the spreadsheet.
+func newDryRunDaemon(conf *config.Config, dir string) (*Daemon, *preview.Renderer, error) {
+	daemon, err := newDaemonWithoutSheets(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var current sheets.Reader
+	googleClient, err := sheets.NewClient(context.Background(), conf.GoogleCredentialsPath)
+	if err != nil {
+		daemon.logger.WithError(err).Warnln("Failed to initialize google client, rendering without diff")
+	} else {
+		daemon.sheets = googleClient
+		current = googleClient.Spreadsheet(conf.GoogleSpreadsheetId)
+	}
+
+	renderer, err := preview.NewRenderer(dir, current, os.Stdout)
+	if err != nil {
+		return nil, nil, err
+	}
+	daemon.writer = renderer
+	return daemon, renderer, nil
+}
+
+// newGitLabClient connects to the GitLab instance of the course.
+func newGitLabClient(conf *config.Config) (*gitlab.Client, error) {
+	return gitlab.NewClient(strings.TrimSuffix(conf.GitLabUrl, "/"), conf.GitLabToken)
+}
+
+func newDaemonWithoutSheets(conf *config.Config) (*Daemon, error) {
+	logger := log.WithField("course", conf.CourseName)
+
+	gitlabClient, err := newGitLabClient(conf)
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to initialize gitlab client")
+		return nil, err
+	}
+
+	reviewersSource, err := reviewers.NewSource(conf.ReviewersPath)
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to load reviewers")
+		return nil, err
+	}
+	logger.Infof("Loaded %d reviewers from %s", reviewersSource.Current().Len(), conf.ReviewersPath)
+
 	return &Daemon{
-		config: conf,
-		gitlab: gitlabClient,
-		sheets: googleClient,
+		course:    conf.CourseName,
+		config:    conf,
+		gitlab:    gitlabClient,
+		reviewers: reviewersSource,
+		logger:    logger,
 	}, nil
 }
 
+// newDaemons creates a daemon per configured course. Courses that fail to
+// initialize are returned as errors and do not prevent the others from
+// starting.
+func newDaemons(conf *config.Config) ([]*Daemon, error) {
+	daemons := make([]*Daemon, 0)
+	failed := make([]string, 0)
+	for _, course := range conf.CourseConfigs() {
+		daemon, err := newDaemon(course)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", course.CourseName, err))
+			continue
+		}
+		daemons = append(daemons, daemon)
+	}
+
+	if len(failed) > 0 {
+		return daemons, errors.New("Failed to initialize courses: " + strings.Join(failed, "; "))
+	}
+	return daemons, nil
+}
+
+func closeDaemons(daemons []*Daemon) {
+	for _, daemon := range daemons {
+		daemon.Close()
+	}
+}
+
 type DeadlinesGroup struct {
 	Group    string
 	Start    string
@@ -65,195 +186,102 @@ type DeadlinesGroup struct {
 	}
 }
 
-func (d *Daemon) listTasksFromDeadlines() ([]string, error) {
-	tasks := make([]string, 0)
-	res, err := http.Get(d.config.DeadlinesUrl)
+func fetchDeadlines(ctx context.Context, url string) ([]DeadlinesGroup, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
 
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return tasks, err
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %s", res.Status)
 	}
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return tasks, err
+		return nil, err
 	}
 
 	deadlines := make([]DeadlinesGroup, 0)
 	err = yaml.Unmarshal(body, &deadlines)
 	if err != nil {
-		log.WithError(err).Warnf("Failed to decode deadlines.yml")
-		return tasks, err
+		logging.Source(ctx, "deadlines").WithError(err).Warnf("Failed to decode deadlines.yml")
+		return nil, err
 	}
 
+	return deadlines, nil
+}
+
+func listTasks(deadlines []DeadlinesGroup) []string {
+	tasks := make([]string, 0)
 	for _, group := range deadlines {
 		for _, task := range group.Tasks {
 			tasks = append(tasks, task.Task)
 		}
 	}
+	return tasks
+}
 
-	return tasks, nil
+func (d *Daemon) listTasksFromDeadlines(ctx context.Context) ([]string, error) {
+	deadlines, err := fetchDeadlines(ctx, d.config.DeadlinesUrl)
+	if err != nil {
+		return make([]string, 0), err
+	}
+	return listTasks(deadlines), nil
 }
 
-func run() error {
+func run(args []string) error {
 	rand.Seed(time.Now().Unix())
 
 	if err := godotenv.Load(); err != nil {
 		log.WithError(err).Warn("Failed to load .env file")
 	}
 
-	logLevel := os.Getenv("LOG_LEVEL")
-	if err := logging.InitLogging(logLevel); err != nil {
-		return err
+	opts := logging.Options{
+		Level:  os.Getenv("LOG_LEVEL"),
+		Format: os.Getenv("LOG_FORMAT"),
+		File:   os.Getenv("LOG_FILE"),
+	}
+	for name, value := range map[string]*int{
+		"LOG_FILE_MAX_SIZE_MB": &opts.MaxSizeMB,
+		"LOG_FILE_MAX_BACKUPS": &opts.MaxBackups,
+	} {
+		if raw := os.Getenv(name); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil {
+				return fmt.Errorf("Invalid %s: %w", name, err)
+			}
+			*value = parsed
+		}
 	}
-	log.Infof("Initialized logging using %s level", logLevel)
 
-	config, err := config.LoadConfig()
+	logFile, err := logging.InitLogging(opts)
 	if err != nil {
 		return err
 	}
-	log.Infoln("Successfully loaded config")
+	defer logFile.Close()
+	log.Infof("Initialized logging using %s level", opts.Level)
 
-	daemon, err := newDaemon(config)
+	config, err := config.LoadConfig()
 	if err != nil {
 		return err
 	}
+	log.Infoln("Successfully loaded config")
 
-	runIter := func() error {
-		tasks, err := daemon.listTasksFromDeadlines()
-		if err != nil {
-			return fmt.Errorf("Failed to get tasks from deadlines.yml: %w", err)
-		}
-
-		taskToIndex := make(map[string]int)
-		for i, task := range tasks {
-			taskToIndex[task] = i
-			log.Debugf("Task %s", task)
-		}
-		log.Infof("Found %d tasks", len(tasks))
-
-		mergeRequestsByStudent := make(map[string][]*mergeRequestTitle)
-		group, err := daemon.gitlab.ListGroupRequests(config.GitLabGroup)
-		if err != nil {
-			log.WithError(err).Errorln("Failed to list group merge requests")
-			return err
-		}
-		log.Printf("Found %d merge requests", group.MergeRequests.Count)
-
-		err = daemon.sheets.WithSnapshot(config.GoogleSpreadsheetId, "Merge Requests", func(snapshot *sheets.Snapshot) error {
-			if err := snapshot.Delete().Do(); err != nil {
-				log.WithError(err).Errorln("Failed to clear table")
-				return err
-			}
-
-			query := snapshot.Insert().Into("Student", "Task", "Merge request title", "Created at", "Merge status", "Pipeline status", "Url")
-
-			titleParser := newMergeRequestTitleParser(config)
-			for _, mr := range group.MergeRequests.Nodes {
-				info := titleParser.parse(mr)
-				if _, found := mergeRequestsByStudent[info.student]; !found {
-					mergeRequestsByStudent[info.student] = make([]*mergeRequestTitle, 0, 1)
-				}
-				mergeRequestsByStudent[info.student] = append(mergeRequestsByStudent[info.student], info)
-				query.Values(info.student, info.task, mr.Title, mr.CreatedAt, mr.MergeStatus, mr.HeadPipeline.Status, mr.WebUrl)
-			}
-			if err := query.Do(); err != nil {
-				log.WithError(err).Errorln("Failed to append merge requests to the table")
-				return err
-			}
-
-			if err := snapshot.Sort().By("Student", "Task").Do(); err != nil {
-				log.WithError(err).Errorln("Failed to sort table")
-				return err
-			}
-
-			return nil
-		})
-		if err != nil {
-			log.WithError(err).Warn("Failed to update Merge Requests table")
-			return err
-		}
-		log.Infoln("Successfully updated Merge Requests table")
-
-		err = daemon.sheets.WithSnapshot(config.GoogleSpreadsheetId, "Reviews", func(snapshot *sheets.Snapshot) error {
-			if err := snapshot.Delete().Do(); err != nil {
-				log.WithError(err).Errorln("Failed to clear table")
-				return err
-			}
-
-			columns := append([]string{"Student"}, tasks...)
-			query := snapshot.Insert().Into(columns...)
-
-			students := make([]string, 0)
-			for student := range mergeRequestsByStudent {
-				students = append(students, student)
-			}
-			sort.Strings(students)
-			for _, student := range students {
-				// fmt.Println(k, mergeRequestsByStudent[k])
-				values := make([]interface{}, len(tasks)+1)
-				values[0] = student
-
-				for _, mr := range mergeRequestsByStudent[student] {
-					text, color := classifyMergeRequestStatus(mr)
-
-					values[1+taskToIndex[mr.task]] = sheets.Cell{
-						Text:            text,
-						Hyperlink:       mr.url,
-						BackgroundColor: color,
-					}
-				}
-
-				query.Values(values...)
-			}
-			if err := query.Do(); err != nil {
-				log.WithError(err).Errorln("Failed to append merge requests to the table")
-				return err
-			}
-
-			if err := snapshot.Sort().By("Student", "Task").Do(); err != nil {
-				log.WithError(err).Errorln("Failed to sort table")
-				return err
-			}
-
-			return nil
-		})
-		if err != nil {
-			log.WithError(err).Warn("Failed to update Reviews table")
-			return err
-		}
-		log.Infoln("Successfully updated Reviews table")
-
-		return nil
-	}
-
-	for {
-		if err := runIter(); err != nil {
-			log.WithError(err).Warn("Iteration failed")
-		}
-		time.Sleep(config.IterationInterval)
-	}
-}
-
-type Reviewer struct {
-	Username  string
-	Pseudonym string
+	return runCommand(config, args)
 }
 
 type mergeRequestTitleParser struct {
 	re        *regexp.Regexp
-	reviewers map[string]*Reviewer
+	reviewers *reviewers.List
 }
 
-func newMergeRequestTitleParser(config *config.Config) *mergeRequestTitleParser {
-	reviewers := make(map[string]*Reviewer)
-	eligibleReviewers := []*Reviewer{}
-	json.Unmarshal([]byte(config.EligibleReviewers), &eligibleReviewers)
-
-	for _, reviewer := range eligibleReviewers {
-		log.Infoln("Found reviewer", reviewer.Username)
-		reviewers[reviewer.Username] = reviewer
-	}
-
+func newMergeRequestTitleParser(reviewers *reviewers.List) *mergeRequestTitleParser {
 	re := regexp.MustCompile(`^\[(\w+)\] \[(\w+)-(\w+)\] (.+/.+)$`)
 	return &mergeRequestTitleParser{
 		re:        re,
@@ -265,29 +293,47 @@ type mergeRequestTitle struct {
 	unversity string
 	student   string
 	task      string
+	title     string
 	url       string
 
 	pipelineStatus      string
 	mergeStatus         string
+	hasConflicts        bool
+	shouldBeRebased     bool
 	numProblems         int
 	numResolvedProblems int
-	approvedBy          []*Reviewer
+	approvedBy          []*reviewers.Reviewer
+
+	createdAt      time.Time
+	lastResolvedAt time.Time
+	lastNoteAt     time.Time
+
+	author    string
+	threads   []*reviewThread
+	approvals []*reviewEvent
 }
 
-func (s *mergeRequestTitleParser) parse(mr *types.MergeRequest) *mergeRequestTitle {
+func (s *mergeRequestTitleParser) parse(ctx context.Context, mr *types.MergeRequest) *mergeRequestTitle {
 	res := &mergeRequestTitle{
+		title:               mr.Title,
 		url:                 mr.WebUrl,
 		pipelineStatus:      mr.HeadPipeline.Status,
 		mergeStatus:         mr.MergeStatus,
+		hasConflicts:        mr.Conflicts,
+		shouldBeRebased:     mr.ShouldBeRebased,
 		numProblems:         0,
 		numResolvedProblems: 0,
-		approvedBy:          make([]*Reviewer, 0),
+		approvedBy:          make([]*reviewers.Reviewer, 0),
+		createdAt:           parseTime(mr.CreatedAt),
+		author:              mr.Author.Username,
+		threads:             make([]*reviewThread, 0),
+		approvals:           make([]*reviewEvent, 0),
 	}
 	for _, user := range mr.ApprovedBy.Nodes {
-		if reviewer, found := s.reviewers[user.Username]; found {
+		if reviewer, found := s.reviewers.Lookup(user.Username); found {
 			res.approvedBy = append(res.approvedBy, reviewer)
 		} else {
-			log.Warnln("Unknown reviewer", user.Username)
+			logging.Source(ctx, "reviewers").Warnln("Unknown reviewer", user.Username)
 		}
 	}
 
@@ -296,8 +342,22 @@ func (s *mergeRequestTitleParser) parse(mr *types.MergeRequest) *mergeRequestTit
 			res.numProblems++
 			if discussion.Resolved {
 				res.numResolvedProblems++
+				res.lastResolvedAt = latest(res.lastResolvedAt, parseTime(discussion.ResolvedAt))
+			}
+		}
+		for _, note := range discussion.Notes.Nodes {
+			if !note.System {
+				res.lastNoteAt = latest(res.lastNoteAt, parseTime(note.CreatedAt))
+			} else if note.Body == approvalNoteBody {
+				res.approvals = append(res.approvals, &reviewEvent{
+					username: note.Author.Username,
+					at:       parseTime(note.CreatedAt),
+				})
 			}
 		}
+		if thread := newReviewThread(discussion); thread != nil {
+			res.threads = append(res.threads, thread)
+		}
 	}
 
 	groups := s.re.FindStringSubmatch(mr.Title)
@@ -341,12 +401,20 @@ func parseHexColor(s string) *sheets.Color {
 	}
 }
 
+func formatHexColor(c *sheets.Color) string {
+	if c == nil {
+		return "none"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", int(c.Red*0xff+0.5), int(c.Green*0xff+0.5), int(c.Blue*0xff+0.5))
+}
+
 var (
 	LightRed    = parseHexColor("#ea9999")
 	LightGreen  = parseHexColor("#b6d7a8")
 	LightYellow = parseHexColor("#fff2cc")
 	LightOrange = parseHexColor("#f9cb9c")
 	LightPurple = parseHexColor("#b4a7d6")
+	LightBlue   = parseHexColor("#a4c2f4")
 )
 
 func firstRune(s *string) rune {
@@ -356,27 +424,92 @@ func firstRune(s *string) rune {
 	return 'w'
 }
 
-func classifyMergeRequestStatus(mr *mergeRequestTitle) (string, *sheets.Color) {
+const (
+	statusApproved         = "Approved"
+	statusNeedsRebase      = "Needs rebase"
+	statusPipelineFailed   = "Pipeline failed"
+	statusRejected         = "Rejected"
+	statusPending          = "Pending"
+	statusProblemsResolved = "Problems resolved"
+)
+
+func (mr *mergeRequestTitle) status() string {
 	if len(mr.approvedBy) > 0 {
+		return statusApproved
+	}
+
+	if mr.needsRebase() {
+		return statusNeedsRebase
+	}
+
+	if mr.pipelineStatus != "SUCCESS" {
+		return statusPipelineFailed
+	}
+
+	if mr.numProblems > mr.numResolvedProblems {
+		return statusRejected
+	}
+
+	if mr.numProblems == 0 {
+		return statusPending
+	} else {
+		return statusProblemsResolved
+	}
+}
+
+func classifyMergeRequestStatus(mr *mergeRequestTitle) (string, *sheets.Color) {
+	switch status := mr.status(); status {
+	case statusApproved:
 		res := "Approved ["
 		for _, user := range mr.approvedBy {
 			res += user.Pseudonym
 		}
 		res += "]"
 		return res, LightGreen
+	case statusNeedsRebase:
+		return status, LightBlue
+	case statusPipelineFailed:
+		return status, LightRed
+	case statusRejected:
+		return status, LightPurple
+	case statusPending:
+		return status, LightYellow
+	default:
+		return status, LightOrange
 	}
+}
 
-	if mr.pipelineStatus != "SUCCESS" {
-		return "Pipeline failed", LightRed
+// GitLab reports merge status in lower case for the REST-compatible
+// mergeStatus field, see MergeStatusEnum.
+const (
+	mergeStatusCannotBeMerged        = "cannot_be_merged"
+	mergeStatusCannotBeMergedRecheck = "cannot_be_merged_recheck"
+)
+
+// needsRebase tells if the author has to update the source branch. While
+// GitLab rechecks mergeability only the conflicts and rebase flags are
+// trusted, the stale merge status alone is not a reason to rebase.
+func (mr *mergeRequestTitle) needsRebase() bool {
+	if mr.mergeStatus == mergeStatusCannotBeMerged {
+		return true
 	}
+	return mr.hasConflicts || mr.shouldBeRebased
+}
 
-	if mr.numProblems > mr.numResolvedProblems {
-		return "Rejected", LightPurple
+func describeMergeProblems(mr *mergeRequestTitle) string {
+	if !mr.needsRebase() {
+		return ""
 	}
 
-	if mr.numProblems == 0 {
-		return "Pending", LightYellow
-	} else {
-		return "Problems resolved", LightOrange
+	problems := make([]string, 0)
+	if mr.hasConflicts {
+		problems = append(problems, "Source branch has conflicts with the target branch")
+	}
+	if mr.shouldBeRebased {
+		problems = append(problems, "Source branch should be rebased onto the target branch")
+	}
+	if mr.mergeStatus != "" {
+		problems = append(problems, "Merge status: "+mr.mergeStatus)
 	}
+	return strings.Join(problems, "\n")
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bigredeye/concurrency_watcher/internal/config"
+	"github.com/bigredeye/concurrency_watcher/internal/history"
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/notify"
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
+	"github.com/bigredeye/concurrency_watcher/internal/roster"
+)
+
+const defaultStudentTemplate = `Your merge request for {{.Task}} is now "{{.To}}" (was "{{.From}}").
+{{if .Reviewers}}Reviewed by: {{.Reviewers}}
+{{end}}{{.Url}}`
+
+const defaultReviewerTemplate = `{{.Student}}'s merge request for {{.Task}} is waiting for review ({{.To}}).
+{{.Url}}`
+
+// Students are told about verdicts, reviewers about merge requests that
+// are waiting for them.
+var (
+	studentStatuses = map[string]bool{
+		statusApproved:       true,
+		statusRejected:       true,
+		statusNeedsRebase:    true,
+		statusPipelineFailed: true,
+	}
+	reviewerStatuses = map[string]bool{
+		statusPending:          true,
+		statusProblemsResolved: true,
+	}
+)
+
+type notificationData struct {
+	Student   string
+	Task      string
+	Title     string
+	Url       string
+	From      string
+	To        string
+	Reviewers string
+}
+
+type notifications struct {
+	dispatcher       *notify.Dispatcher
+	roster           *roster.Roster
+	studentTemplate  *template.Template
+	reviewerTemplate *template.Template
+}
+
+// newNotifications returns nil if no notifier is configured.
+func newNotifications(conf *config.Config) (*notifications, error) {
+	notifiers := make([]notify.Notifier, 0)
+	if conf.NotifyWebhookUrl != "" {
+		notifiers = append(notifiers, notify.NewWebhook(conf.NotifyWebhookUrl))
+	}
+	if conf.NotifyTelegramToken != "" {
+		notifiers = append(notifiers, notify.NewTelegram(conf.NotifyTelegramUrl, conf.NotifyTelegramToken))
+	}
+	if conf.NotifySmtpAddress != "" {
+		notifiers = append(notifiers, notify.NewSmtp(conf.NotifySmtpAddress, conf.NotifySmtpFrom, conf.NotifySmtpUsername, conf.NotifySmtpPassword))
+	}
+	if len(notifiers) == 0 {
+		return nil, nil
+	}
+
+	contacts := roster.Empty()
+	if conf.RosterPath != "" {
+		var err error
+		contacts, err = roster.Load(conf.RosterPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	studentTemplate, err := parseNotificationTemplate("student", conf.NotifyStudentTemplate, defaultStudentTemplate)
+	if err != nil {
+		return nil, err
+	}
+	reviewerTemplate, err := parseNotificationTemplate("reviewer", conf.NotifyReviewerTemplate, defaultReviewerTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &notifications{
+		dispatcher:       notify.NewDispatcher(notifiers...),
+		roster:           contacts,
+		studentTemplate:  studentTemplate,
+		reviewerTemplate: reviewerTemplate,
+	}, nil
+}
+
+func parseNotificationTemplate(name string, text string, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s notification template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+func (n *notifications) send(ctx context.Context, transitions []*history.Transition, mergeRequestsByStudent map[string][]*mergeRequestTitle, list *reviewers.List, stats *reviewerStats, now time.Time) {
+	byUrl := make(map[string]*mergeRequestTitle)
+	for _, mrs := range mergeRequestsByStudent {
+		for _, mr := range mrs {
+			byUrl[mr.url] = mr
+		}
+	}
+
+	for _, transition := range transitions {
+		mr, found := byUrl[transition.Url]
+		if !found {
+			continue
+		}
+
+		involved := mr.involvedReviewers(list)
+		data := &notificationData{
+			Student:   mr.student,
+			Task:      mr.task,
+			Title:     mr.title,
+			Url:       mr.url,
+			From:      transition.From,
+			To:        transition.To,
+			Reviewers: strings.Join(pseudonyms(involved), ", "),
+		}
+
+		if transition.From != "" && studentStatuses[transition.To] {
+			n.sendStudent(ctx, mr, data)
+		}
+
+		if reviewerStatuses[transition.To] {
+			if len(involved) == 0 {
+				involved = stats.available(mr.task, now)
+			}
+			for _, reviewer := range involved {
+				n.sendReviewer(ctx, reviewer, data)
+			}
+		}
+	}
+}
+
+func (n *notifications) sendStudent(ctx context.Context, mr *mergeRequestTitle, data *notificationData) {
+	recipient := notify.Recipient{
+		Name:     mr.student,
+		Username: mr.author,
+		Role:     "student",
+	}
+	if student, found := n.roster.Lookup(mr.author); found {
+		recipient.Email = student.Email
+		recipient.Telegram = student.Telegram
+	}
+
+	n.render(ctx, recipient, n.studentTemplate, data)
+}
+
+func (n *notifications) sendReviewer(ctx context.Context, reviewer *reviewers.Reviewer, data *notificationData) {
+	recipient := notify.Recipient{
+		Name:     reviewer.Pseudonym,
+		Username: reviewer.Username,
+		Role:     "reviewer",
+		Email:    reviewer.Email,
+		Telegram: reviewer.Telegram,
+	}
+
+	n.render(ctx, recipient, n.reviewerTemplate, data)
+}
+
+func (n *notifications) render(ctx context.Context, recipient notify.Recipient, tmpl *template.Template, data *notificationData) {
+	var text strings.Builder
+	if err := tmpl.Execute(&text, data); err != nil {
+		logging.FromContext(ctx).WithError(err).Warnf("Failed to render %s notification", tmpl.Name())
+		return
+	}
+
+	n.dispatcher.Send(ctx, &notify.Message{
+		Recipient: recipient,
+		Subject:   fmt.Sprintf("%s: %s", data.Task, data.To),
+		Text:      text.String(),
+	})
+}
+
+// involvedReviewers returns known reviewers who opened threads on the
+// merge request or approved it.
+func (mr *mergeRequestTitle) involvedReviewers(list *reviewers.List) []*reviewers.Reviewer {
+	seen := make(map[string]bool)
+	res := make([]*reviewers.Reviewer, 0)
+	add := func(username string) {
+		if seen[username] {
+			return
+		}
+		if reviewer, found := list.Lookup(username); found {
+			seen[username] = true
+			res = append(res, reviewer)
+		}
+	}
+
+	for _, thread := range mr.threads {
+		add(thread.openedBy)
+	}
+	for _, reviewer := range mr.approvedBy {
+		add(reviewer.Username)
+	}
+	return res
+}
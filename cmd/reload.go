@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigredeye/concurrency_watcher/internal/config"
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+// reloader re-reads the config on SIGHUP and when the config file changes.
+// Valid configs are handed to the daemons, which apply them between
+// iterations; invalid ones are logged together with the diff and ignored.
+type reloader struct {
+	mu      sync.Mutex
+	current *config.Config
+	daemons []*Daemon
+}
+
+func newReloader(conf *config.Config, daemons []*Daemon) *reloader {
+	for _, daemon := range daemons {
+		daemon.configs = make(chan *config.Config, 1)
+	}
+	return &reloader{
+		current: conf,
+		daemons: daemons,
+	}
+}
+
+func (r *reloader) reload(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.Reload()
+	if err != nil {
+		log.WithError(err).Errorf("Failed to reload config after %s, keeping the current one", reason)
+		return
+	}
+
+	diff := config.Diff(r.current, next)
+	if err := next.Validate(); err != nil {
+		log.WithError(err).WithField("diff", strings.Join(diff, "; ")).Errorf("Config reloaded after %s is invalid, keeping the current one", reason)
+		return
+	}
+	if len(diff) == 0 {
+		log.Debugf("Config is unchanged after %s", reason)
+		return
+	}
+	log.WithField("diff", strings.Join(diff, "; ")).Infof("Config changed after %s", reason)
+
+	if next.MetricsAddress != r.current.MetricsAddress {
+		log.Warnln("Changing the metrics address requires a restart")
+	}
+
+	courses := make(map[string]*config.Config)
+	for _, course := range next.CourseConfigs() {
+		courses[course.CourseName] = course
+	}
+	for _, daemon := range r.daemons {
+		course, found := courses[daemon.course]
+		if !found {
+			daemon.logger.Warnln("Course was removed from the config, it keeps running until a restart")
+			continue
+		}
+		delete(courses, daemon.course)
+
+		select {
+		case <-daemon.configs:
+		default:
+		}
+		daemon.configs <- course
+	}
+	for name := range courses {
+		log.WithField("course", name).Warnln("Course was added to the config, it starts after a restart")
+	}
+
+	r.current = next
+}
+
+// reconfigure switches the daemon to the new config. Clients depending on
+// changed fields are recreated first, so that on failure the daemon keeps
+// working with the old config.
+func (d *Daemon) reconfigure(next *config.Config) {
+	prev := d.config
+	if next.HistoryPath != prev.HistoryPath {
+		d.logger.Warnln("Changing the history path requires a restart")
+		next.HistoryPath = prev.HistoryPath
+	}
+
+	gitlabClient := d.gitlab
+	if next.GitLabUrl != prev.GitLabUrl || next.GitLabToken != prev.GitLabToken {
+		client, err := newGitLabClient(next)
+		if err != nil {
+			d.logger.WithError(err).Errorln("Failed to apply new config, keeping the current one")
+			return
+		}
+		gitlabClient = client
+	}
+
+	googleClient, writer := d.sheets, d.writer
+	if next.GoogleCredentialsPath != prev.GoogleCredentialsPath || next.GoogleSpreadsheetId != prev.GoogleSpreadsheetId || next.SheetsUpdateMode != prev.SheetsUpdateMode {
+		if next.GoogleCredentialsPath != prev.GoogleCredentialsPath {
+			client, err := sheets.NewClient(context.Background(), next.GoogleCredentialsPath)
+			if err != nil {
+				d.logger.WithError(err).Errorln("Failed to apply new config, keeping the current one")
+				return
+			}
+			googleClient = client
+		}
+		writer = googleClient.Spreadsheet(next.GoogleSpreadsheetId).UpdateMode(next.SheetsUpdateMode)
+	}
+
+	var reviewersSource *reviewers.Source
+	if next.ReviewersPath != prev.ReviewersPath {
+		source, err := reviewers.NewSource(next.ReviewersPath)
+		if err != nil {
+			d.logger.WithError(err).Errorln("Failed to apply new config, keeping the current one")
+			return
+		}
+		if err := source.Watch(); err != nil {
+			d.logger.WithError(err).Warnln("Failed to watch reviewers file, changes will require a restart")
+		}
+		reviewersSource = source
+	}
+
+	notifications, err := newNotifications(next)
+	if err != nil {
+		if reviewersSource != nil {
+			reviewersSource.Close()
+		}
+		d.logger.WithError(err).Errorln("Failed to apply new config, keeping the current one")
+		return
+	}
+
+	d.config = next
+	d.gitlab = gitlabClient
+	d.sheets = googleClient.CreateMissing(sheetOptions(next))
+	d.writer = writer
+	d.notifications = notifications
+	if reviewersSource != nil {
+		d.reviewers.Close()
+		d.reviewers = reviewersSource
+	}
+	d.logger.Infoln("Applied new config")
+}
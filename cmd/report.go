@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bigredeye/concurrency_watcher/internal/config"
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+// Report renders one generated sheet from the data fetched in an iteration.
+// Adding a tab means implementing Report and registering it in init.
+type Report interface {
+	// Name identifies the report in REPORTS and DISABLED_REPORTS.
+	Name() string
+	Sheet() string
+	Build(data *dataset) (*sheets.Table, error)
+}
+
+var (
+	reportRegistry = make(map[string]Report)
+	reportOrder    = make([]string, 0)
+)
+
+func registerReport(report Report) {
+	if _, found := reportRegistry[report.Name()]; found {
+		panic(fmt.Sprintf("report %q is registered twice", report.Name()))
+	}
+	reportRegistry[report.Name()] = report
+	reportOrder = append(reportOrder, report.Name())
+}
+
+// enabledReports returns reports listed in REPORTS, or every registered
+// report if it is empty, minus DISABLED_REPORTS, in registration order.
+func enabledReports(conf *config.Config) ([]Report, error) {
+	unknown := make([]string, 0)
+	check := func(names []string) map[string]bool {
+		res := make(map[string]bool)
+		for _, name := range names {
+			name = strings.TrimSpace(name)
+			if _, found := reportRegistry[name]; !found {
+				unknown = append(unknown, name)
+			}
+			res[name] = true
+		}
+		return res
+	}
+
+	enabled := check(conf.Reports)
+	disabled := check(conf.DisabledReports)
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("Unknown reports %s, known reports are %s", strings.Join(unknown, ", "), strings.Join(reportOrder, ", "))
+	}
+
+	res := make([]Report, 0, len(reportOrder))
+	for _, name := range reportOrder {
+		if len(enabled) > 0 && !enabled[name] {
+			continue
+		}
+		if disabled[name] {
+			continue
+		}
+		res = append(res, reportRegistry[name])
+	}
+	return res, nil
+}
+
+// dataset is everything reports are built from. It is assembled once per
+// iteration and must not be modified by reports.
+type dataset struct {
+	now                    time.Time
+	tasks                  []string
+	taskToIndex            map[string]int
+	mergeRequests          []*mergeRequestTitle
+	mergeRequestsByStudent map[string][]*mergeRequestTitle
+	students               []string
+	reviewers              *reviewers.List
+	stats                  *reviewerStats
+	turnaround             *turnaroundTracker
+}
+
+func newDataset(tasks []string, mergeRequests []*mergeRequestTitle, list *reviewers.List, turnaround *turnaroundTracker) *dataset {
+	data := &dataset{
+		now:                    turnaround.now,
+		tasks:                  tasks,
+		taskToIndex:            make(map[string]int),
+		mergeRequests:          mergeRequests,
+		mergeRequestsByStudent: make(map[string][]*mergeRequestTitle),
+		students:               make([]string, 0),
+		reviewers:              list,
+		stats:                  newReviewerStats(list),
+		turnaround:             turnaround,
+	}
+
+	for i, task := range tasks {
+		data.taskToIndex[task] = i
+	}
+
+	for _, mr := range mergeRequests {
+		if _, found := data.mergeRequestsByStudent[mr.student]; !found {
+			data.students = append(data.students, mr.student)
+		}
+		data.mergeRequestsByStudent[mr.student] = append(data.mergeRequestsByStudent[mr.student], mr)
+		data.stats.add(mr)
+	}
+	sort.Strings(data.students)
+
+	return data
+}
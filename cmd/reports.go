@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+const (
+	sheetMergeRequests   = "Merge Requests"
+	sheetReviews         = "Reviews"
+	sheetReviewQueue     = "Review queue"
+	sheetReviewersByWeek = "Reviewers by week"
+	sheetReviewers       = "Reviewers"
+)
+
+// tableReport is a Report defined by a build function.
+type tableReport struct {
+	name  string
+	sheet string
+	build func(data *dataset) (*sheets.Table, error)
+}
+
+func (r *tableReport) Name() string {
+	return r.name
+}
+
+func (r *tableReport) Sheet() string {
+	return r.sheet
+}
+
+func (r *tableReport) Build(data *dataset) (*sheets.Table, error) {
+	return r.build(data)
+}
+
+func init() {
+	registerReport(&tableReport{name: "merge_requests", sheet: sheetMergeRequests, build: buildMergeRequests})
+	registerReport(&tableReport{name: "reviews", sheet: sheetReviews, build: buildReviews})
+	registerReport(&tableReport{name: "review_queue", sheet: sheetReviewQueue, build: buildReviewQueue})
+	registerReport(&tableReport{name: "reviewers", sheet: sheetReviewers, build: buildReviewers})
+	registerReport(&tableReport{name: "reviewers_by_week", sheet: sheetReviewersByWeek, build: buildReviewersByWeek})
+}
+
+// buildMergeRequests lists merge requests keyed by url, so that columns
+// added to the sheet by hand stay next to their merge request.
+func buildMergeRequests(data *dataset) (*sheets.Table, error) {
+	table := sheets.NewTable("Student", "Task", "Merge request title", "Created at", "Merge status", "Pipeline status", "Url").Sort("Student", "Task").KeyBy("Url")
+	for _, mr := range data.mergeRequests {
+		var createdAt interface{}
+		if !mr.createdAt.IsZero() {
+			createdAt = mr.createdAt
+		}
+		table.Append(mr.student, mr.task, mr.title, createdAt, mr.mergeStatus, mr.pipelineStatus, mr.url)
+	}
+	return table, nil
+}
+
+// buildReviews lays merge requests out as a student by task grid. Task
+// columns follow deadlines.yml, so the grid is rewritten rather than
+// keyed: columns of removed tasks are cleared and moved tasks follow the
+// new order. Merge requests for tasks missing from deadlines.yml are left
+// out.
+func buildReviews(data *dataset) (*sheets.Table, error) {
+	table := sheets.NewTable(append([]string{"Student"}, data.tasks...)...).Sort("Student")
+	for _, student := range data.students {
+		values := make([]interface{}, len(data.tasks)+1)
+		values[0] = student
+
+		for _, mr := range data.mergeRequestsByStudent[student] {
+			index, found := data.taskToIndex[mr.task]
+			if !found {
+				// Logged when the dataset is assembled.
+				continue
+			}
+			text, color := data.turnaround.classify(mr)
+
+			values[1+index] = sheets.Cell{
+				Text:            text,
+				Hyperlink:       mr.url,
+				Note:            describeMergeProblems(mr),
+				BackgroundColor: color,
+			}
+		}
+
+		table.Append(values...)
+	}
+	return table, nil
+}
+
+// buildReviewQueue lists merge requests waiting for a reviewer, longest
+// waiting first.
+func buildReviewQueue(data *dataset) (*sheets.Table, error) {
+	queue := make([]*mergeRequestTitle, 0)
+	for _, mr := range data.mergeRequests {
+		if _, waiting := mr.waitingSince(); waiting {
+			queue = append(queue, mr)
+		}
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		lhs, _ := queue[i].waitingSince()
+		rhs, _ := queue[j].waitingSince()
+		return lhs.Before(rhs)
+	})
+
+	table := sheets.NewTable("Student", "Task", "Status", "Waiting since", "Waiting", "Available reviewers", "Url")
+	for _, mr := range queue {
+		since, _ := mr.waitingSince()
+		_, color := data.turnaround.classify(mr)
+		table.Append(mr.student, mr.task, mr.status(), since, sheets.Cell{
+			Text:            formatAge(data.now.Sub(since)),
+			BackgroundColor: color,
+		}, strings.Join(pseudonyms(data.stats.available(mr.task, data.now)), ", "), mr.url)
+	}
+	return table, nil
+}
+
+func buildReviewers(data *dataset) (*sheets.Table, error) {
+	table := sheets.NewTable("Reviewer", "Pseudonym", "Approved", "Threads opened", "Threads resolved by students", "Median time to first review", "Waiting", "Max load")
+	for _, reviewer := range data.stats.sorted() {
+		table.Append(
+			reviewer.username,
+			reviewer.pseudonym,
+			reviewer.approved,
+			reviewer.threadsOpened,
+			reviewer.threadsResolved,
+			reviewer.formatMedianFirstReview(),
+			reviewer.waiting,
+			reviewer.maxLoad,
+		)
+	}
+	return table, nil
+}
+
+func buildReviewersByWeek(data *dataset) (*sheets.Table, error) {
+	table := sheets.NewTable("Week", "Reviewer", "Approved", "Threads opened", "First reviews")
+	for _, week := range data.stats.weeklySorted() {
+		table.Append(
+			sheets.Cell{Value: week.week, Format: "yyyy-mm-dd"},
+			week.username,
+			week.approved,
+			week.threadsOpened,
+			week.firstReviews,
+		)
+	}
+	return table, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bigredeye/concurrency_watcher/internal/config"
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+func TestBuildReviewsSkipsUnknownTasks(t *testing.T) {
+	list, err := reviewers.Parse([]byte("reviewers: []\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.Config{ReviewWarnAfter: 72 * time.Hour, ReviewAlertAfter: 168 * time.Hour}
+	mergeRequests := []*mergeRequestTitle{
+		{student: "alice", task: "mutex", url: "https://gitlab/mr/1", pipelineStatus: "SUCCESS"},
+		{student: "alice", task: "unknown", url: "https://gitlab/mr/2", pipelineStatus: "SUCCESS"},
+	}
+	data := newDataset([]string{"mutex", "futex"}, mergeRequests, list, newTurnaroundTracker(conf, time.Now()))
+
+	table, err := buildReviews(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(table.Rows))
+	}
+	row := table.Rows[0]
+	if row[0] != "alice" {
+		t.Errorf("student is %v, want alice", row[0])
+	}
+	if cell, ok := row[1].(sheets.Cell); !ok || cell.Hyperlink != "https://gitlab/mr/1" {
+		t.Errorf("mutex cell is %v, want the mutex merge request", row[1])
+	}
+	if row[2] != nil {
+		t.Errorf("futex cell is %v, want empty", row[2])
+	}
+}
+
+func TestBuildReviewsFollowsDeadlines(t *testing.T) {
+	list, err := reviewers.Parse([]byte("reviewers: []\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.Config{ReviewWarnAfter: 72 * time.Hour, ReviewAlertAfter: 168 * time.Hour}
+	mergeRequests := []*mergeRequestTitle{
+		{student: "alice", task: "mutex", url: "https://gitlab/mr/1", pipelineStatus: "SUCCESS"},
+		{student: "alice", task: "futex", url: "https://gitlab/mr/2", pipelineStatus: "SUCCESS"},
+		{student: "alice", task: "cond", url: "https://gitlab/mr/3", pipelineStatus: "SUCCESS"},
+	}
+
+	tests := []struct {
+		name  string
+		tasks []string
+		want  []string
+		urls  []string
+	}{
+		{
+			name:  "all tasks",
+			tasks: []string{"mutex", "futex", "cond"},
+			want:  []string{"Student", "mutex", "futex", "cond"},
+			urls:  []string{"https://gitlab/mr/1", "https://gitlab/mr/2", "https://gitlab/mr/3"},
+		},
+		{
+			name:  "task removed and tasks reordered",
+			tasks: []string{"cond", "mutex"},
+			want:  []string{"Student", "cond", "mutex"},
+			urls:  []string{"https://gitlab/mr/3", "https://gitlab/mr/1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := newDataset(tt.tasks, mergeRequests, list, newTurnaroundTracker(conf, time.Now()))
+			table, err := buildReviews(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(table.Key) != 0 {
+				t.Errorf("table is keyed by %v, stale task columns would be kept", table.Key)
+			}
+			if !reflect.DeepEqual(table.Columns, tt.want) {
+				t.Errorf("columns are %v, want %v", table.Columns, tt.want)
+			}
+			if len(table.Rows) != 1 {
+				t.Fatalf("got %d rows, want 1", len(table.Rows))
+			}
+			for i, url := range tt.urls {
+				if cell, ok := table.Rows[0][1+i].(sheets.Cell); !ok || cell.Hyperlink != url {
+					t.Errorf("%s cell is %v, want %s", tt.want[1+i], table.Rows[0][1+i], url)
+				}
+			}
+		})
+	}
+}
+
+func TestDescribeMergeProblems(t *testing.T) {
+	tests := []struct {
+		name        string
+		mr          *mergeRequestTitle
+		needsRebase bool
+		want        []string
+	}{
+		{
+			name: "mergeable",
+			mr:   &mergeRequestTitle{mergeStatus: "can_be_merged"},
+		},
+		{
+			name:        "conflicts",
+			mr:          &mergeRequestTitle{mergeStatus: mergeStatusCannotBeMerged, hasConflicts: true},
+			needsRebase: true,
+			want:        []string{"Source branch has conflicts with the target branch", "Merge status: cannot_be_merged"},
+		},
+		{
+			name:        "should be rebased",
+			mr:          &mergeRequestTitle{mergeStatus: "can_be_merged", shouldBeRebased: true},
+			needsRebase: true,
+			want:        []string{"Source branch should be rebased onto the target branch"},
+		},
+		{
+			name:        "recheck is pending with conflicts",
+			mr:          &mergeRequestTitle{mergeStatus: mergeStatusCannotBeMergedRecheck, hasConflicts: true},
+			needsRebase: true,
+			want:        []string{"Source branch has conflicts with the target branch", "Merge status: cannot_be_merged_recheck"},
+		},
+		{
+			name: "recheck is pending without conflicts",
+			mr:   &mergeRequestTitle{mergeStatus: mergeStatusCannotBeMergedRecheck},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mr.needsRebase(); got != tt.needsRebase {
+				t.Errorf("needsRebase = %t, want %t", got, tt.needsRebase)
+			}
+			note := describeMergeProblems(tt.mr)
+			if len(tt.want) == 0 && note != "" {
+				t.Errorf("unexpected note %q", note)
+			}
+			for _, line := range tt.want {
+				if !strings.Contains(note, line) {
+					t.Errorf("note %q does not contain %q", note, line)
+				}
+			}
+		})
+	}
+}
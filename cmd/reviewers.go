@@ -0,0 +1,243 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bigredeye/concurrency_watcher/internal/reviewers"
+	"github.com/bigredeye/concurrency_watcher/internal/types"
+)
+
+// GitLab records approvals as system notes with this exact body.
+const approvalNoteBody = "approved this merge request"
+
+type reviewEvent struct {
+	username string
+	at       time.Time
+}
+
+type reviewThread struct {
+	openedBy   string
+	openedAt   time.Time
+	resolved   bool
+	resolvedBy string
+}
+
+func newReviewThread(discussion *types.Discussion) *reviewThread {
+	if !discussion.Resolvable || len(discussion.Notes.Nodes) == 0 {
+		return nil
+	}
+
+	first := discussion.Notes.Nodes[0]
+	thread := &reviewThread{
+		openedBy: first.Author.Username,
+		openedAt: parseTime(first.CreatedAt),
+		resolved: discussion.Resolved,
+	}
+	if discussion.ResolvedBy != nil {
+		thread.resolvedBy = discussion.ResolvedBy.Username
+	}
+	return thread
+}
+
+// firstReview returns the earliest thread or approval left on the merge
+// request by one of the known reviewers.
+func (mr *mergeRequestTitle) firstReview(reviewers *reviewers.List) *reviewEvent {
+	var first *reviewEvent
+	consider := func(username string, at time.Time) {
+		if _, found := reviewers.Lookup(username); !found || at.IsZero() {
+			return
+		}
+		if first == nil || at.Before(first.at) {
+			first = &reviewEvent{username: username, at: at}
+		}
+	}
+
+	for _, thread := range mr.threads {
+		consider(thread.openedBy, thread.openedAt)
+	}
+	for _, approval := range mr.approvals {
+		consider(approval.username, approval.at)
+	}
+	return first
+}
+
+type reviewerSummary struct {
+	username  string
+	pseudonym string
+	maxLoad   int
+
+	approved        int
+	threadsOpened   int
+	threadsResolved int
+	waiting         int
+	firstReviews    []time.Duration
+}
+
+func (r *reviewerSummary) formatMedianFirstReview() string {
+	if len(r.firstReviews) == 0 {
+		return ""
+	}
+
+	durations := append([]time.Duration(nil), r.firstReviews...)
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+
+	median := durations[len(durations)/2]
+	if len(durations)%2 == 0 {
+		median = (durations[len(durations)/2-1] + median) / 2
+	}
+	return formatAge(median)
+}
+
+type weeklyReviewerSummary struct {
+	week     time.Time
+	username string
+
+	approved      int
+	threadsOpened int
+	firstReviews  int
+}
+
+type weeklyKey struct {
+	week     time.Time
+	username string
+}
+
+type reviewerStats struct {
+	reviewers *reviewers.List
+	summaries map[string]*reviewerSummary
+	weekly    map[weeklyKey]*weeklyReviewerSummary
+}
+
+func newReviewerStats(reviewers *reviewers.List) *reviewerStats {
+	stats := &reviewerStats{
+		reviewers: reviewers,
+		summaries: make(map[string]*reviewerSummary),
+		weekly:    make(map[weeklyKey]*weeklyReviewerSummary),
+	}
+	for _, reviewer := range reviewers.All() {
+		stats.summaries[reviewer.Username] = &reviewerSummary{
+			username:  reviewer.Username,
+			pseudonym: reviewer.Pseudonym,
+			maxLoad:   reviewer.MaxLoad,
+		}
+	}
+	return stats
+}
+
+// available lists active reviewers who accept the task and have not
+// reached their maximum load yet.
+func (s *reviewerStats) available(task string, at time.Time) []*reviewers.Reviewer {
+	res := make([]*reviewers.Reviewer, 0)
+	for _, reviewer := range s.reviewers.All() {
+		if !reviewer.IsActive(at) || !reviewer.Reviews(task) {
+			continue
+		}
+		summary := s.summaries[reviewer.Username]
+		if summary.maxLoad > 0 && summary.waiting >= summary.maxLoad {
+			continue
+		}
+		res = append(res, reviewer)
+	}
+	return res
+}
+
+func (s *reviewerStats) add(mr *mergeRequestTitle) {
+	for _, reviewer := range mr.approvedBy {
+		if summary, found := s.summaries[reviewer.Username]; found {
+			summary.approved++
+		}
+	}
+	for _, approval := range mr.approvals {
+		if week := s.week(approval.username, approval.at); week != nil {
+			week.approved++
+		}
+	}
+
+	waitingFor := make(map[string]bool)
+	for _, thread := range mr.threads {
+		summary, found := s.summaries[thread.openedBy]
+		if !found {
+			continue
+		}
+
+		summary.threadsOpened++
+		if thread.resolved && thread.resolvedBy == mr.author {
+			summary.threadsResolved++
+		}
+		if week := s.week(thread.openedBy, thread.openedAt); week != nil {
+			week.threadsOpened++
+		}
+		waitingFor[thread.openedBy] = true
+	}
+	if mr.status() == statusProblemsResolved {
+		for username := range waitingFor {
+			s.summaries[username].waiting++
+		}
+	}
+
+	if first := mr.firstReview(s.reviewers); first != nil {
+		summary := s.summaries[first.username]
+		if !mr.createdAt.IsZero() {
+			summary.firstReviews = append(summary.firstReviews, first.at.Sub(mr.createdAt))
+		}
+		if week := s.week(first.username, first.at); week != nil {
+			week.firstReviews++
+		}
+	}
+}
+
+func (s *reviewerStats) week(username string, at time.Time) *weeklyReviewerSummary {
+	if _, found := s.reviewers.Lookup(username); !found || at.IsZero() {
+		return nil
+	}
+
+	key := weeklyKey{week: startOfWeek(at), username: username}
+	week, found := s.weekly[key]
+	if !found {
+		week = &weeklyReviewerSummary{week: key.week, username: username}
+		s.weekly[key] = week
+	}
+	return week
+}
+
+func (s *reviewerStats) sorted() []*reviewerSummary {
+	res := make([]*reviewerSummary, 0, len(s.summaries))
+	for _, summary := range s.summaries {
+		res = append(res, summary)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].username < res[j].username
+	})
+	return res
+}
+
+func (s *reviewerStats) weeklySorted() []*weeklyReviewerSummary {
+	res := make([]*weeklyReviewerSummary, 0, len(s.weekly))
+	for _, week := range s.weekly {
+		res = append(res, week)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].week.Equal(res[j].week) {
+			return res[i].week.After(res[j].week)
+		}
+		return res[i].username < res[j].username
+	})
+	return res
+}
+
+func startOfWeek(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+func pseudonyms(list []*reviewers.Reviewer) []string {
+	res := make([]string, 0, len(list))
+	for _, reviewer := range list {
+		res = append(res, reviewer.Pseudonym)
+	}
+	return res
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigredeye/concurrency_watcher/internal/config"
+	"github.com/bigredeye/concurrency_watcher/internal/history"
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+// generatedSheets returns tabs the daemon writes with the current config.
+func generatedSheets(conf *config.Config) ([]string, error) {
+	reports, err := enabledReports(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(reports)+2)
+	for _, report := range reports {
+		res = append(res, report.Sheet())
+	}
+	return append(res, sheetEvents, sheetEventsArchive), nil
+}
+
+// sheetOptions tells how to create missing sheets, or returns nil if
+// that is disabled. Generated sheets are placed in the order of
+// generatedSheets unless their layout sets a position.
+func sheetOptions(conf *config.Config) func(sheet string) sheets.SheetOptions {
+	if !conf.SheetsCreateMissing {
+		return nil
+	}
+
+	// Unknown report names are reported by validation.
+	order, _ := generatedSheets(conf)
+	return func(sheet string) sheets.SheetOptions {
+		options := sheets.SheetOptions{
+			Index:         -1,
+			FreezeHeader:  conf.SheetsFreezeHeader,
+			ProtectHeader: conf.SheetsProtectHeader,
+		}
+		for i, name := range order {
+			if name == sheet {
+				options.Index = i
+			}
+		}
+
+		layout := conf.SheetLayout[strings.ToLower(sheet)]
+		if layout.Position > 0 {
+			options.Index = layout.Position - 1
+		}
+		if layout.TabColor != "" {
+			options.TabColor, _ = sheets.ParseColor(layout.TabColor)
+		}
+		return options
+	}
+}
+
+// sync fetches merge requests once and renders every enabled report.
+func (d *Daemon) sync(ctx context.Context) error {
+	logger := logging.FromContext(ctx)
+	reports, err := enabledReports(d.config)
+	if err != nil {
+		return err
+	}
+	d.sweepSnapshots(ctx)
+
+	tasks, err := d.listTasksFromDeadlines(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to get tasks from deadlines.yml: %w", err)
+	}
+	for _, task := range tasks {
+		logger.Debugf("Task %s", task)
+	}
+	logger.Infof("Found %d tasks", len(tasks))
+
+	group, err := d.gitlab.ListGroupRequests(ctx, d.config.GitLabGroup, d.config.GitLabLabel)
+	if err != nil {
+		logger.WithError(err).Errorln("Failed to list group merge requests")
+		return err
+	}
+	logger.Printf("Found %d merge requests", group.MergeRequests.Count)
+
+	titleParser := newMergeRequestTitleParser(d.reviewers.Current())
+	mergeRequests := make([]*mergeRequestTitle, 0, len(group.MergeRequests.Nodes))
+	for _, mr := range group.MergeRequests.Nodes {
+		mergeRequests = append(mergeRequests, titleParser.parse(ctx, mr))
+	}
+	data := newDataset(tasks, mergeRequests, titleParser.reviewers, newTurnaroundTracker(d.config, time.Now()))
+	for _, mr := range data.mergeRequests {
+		if _, found := data.taskToIndex[mr.task]; !found {
+			logger.WithField("url", mr.url).Warnf("Unknown task %q", mr.task)
+		}
+	}
+
+	snapshots, transitions, initial, err := d.observe(ctx, data.mergeRequestsByStudent, data.now)
+	if err != nil {
+		logger.WithError(err).Warnln("Failed to read merge request history")
+	}
+
+	statusCounts := make(map[string]int)
+	for _, mr := range data.mergeRequests {
+		statusCounts[mr.status()]++
+	}
+	metrics.SetMergeRequests(d.config.CourseName, statusCounts)
+
+	if !initial {
+		if err := d.appendEvents(ctx, transitions, data.mergeRequestsByStudent, data.reviewers); err != nil {
+			return err
+		}
+	}
+	// The snapshots are recorded only once their events are in the sheet,
+	// otherwise a failed append would lose the transitions for good.
+	if err := d.record(snapshots); err != nil {
+		logger.WithError(err).Warnln("Failed to record merge request history")
+	}
+
+	if d.notifications != nil && !initial {
+		d.notifications.send(ctx, transitions, data.mergeRequestsByStudent, data.reviewers, data.stats, data.now)
+	}
+
+	// A report failing to build does not stop the others. The built ones
+	// are written together, so the sheets never show data from different
+	// iterations.
+	var firstErr error
+	tables := make([]sheets.NamedTable, 0, len(reports))
+	for _, report := range reports {
+		table, err := report.Build(data)
+		if err != nil {
+			logger.WithError(err).Warnf("Failed to build %s report", report.Name())
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Failed to build %s report: %w", report.Name(), err)
+			}
+			continue
+		}
+		tables = append(tables, sheets.NamedTable{Sheet: report.Sheet(), Table: table})
+	}
+	if err := d.writeTables(ctx, tables); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+type snapshotSweeper interface {
+	SweepSnapshots(ctx context.Context, maxAge time.Duration) error
+}
+
+// sweepSnapshots removes snapshot sheets left behind by crashed processes
+// on the first iteration and then every SNAPSHOT_SWEEP_INTERVAL. Failures
+// are only logged, the next attempt is made on the next iteration.
+func (d *Daemon) sweepSnapshots(ctx context.Context) {
+	sweeper, ok := d.writer.(snapshotSweeper)
+	if !ok || time.Since(d.lastSweep) < d.config.SnapshotSweepInterval {
+		return
+	}
+	if err := sweeper.SweepSnapshots(ctx, d.config.SnapshotMaxAge); err != nil {
+		logging.FromContext(ctx).WithError(err).Warnln("Failed to sweep stale snapshot sheets")
+		return
+	}
+	d.lastSweep = time.Now()
+}
+
+type tablesWriter interface {
+	WriteTables(ctx context.Context, tables []sheets.NamedTable) error
+}
+
+// writeTables updates all sheets at once if the writer supports it and
+// one by one otherwise.
+func (d *Daemon) writeTables(ctx context.Context, tables []sheets.NamedTable) error {
+	logger := logging.FromContext(ctx)
+	if w, ok := d.writer.(tablesWriter); ok {
+		if err := w.WriteTables(ctx, tables); err != nil {
+			logger.WithError(err).Warnln("Failed to update tables")
+			return err
+		}
+		logger.Infof("Successfully updated %d tables", len(tables))
+		return nil
+	}
+
+	var firstErr error
+	for _, named := range tables {
+		if err := d.writeTable(ctx, named.Sheet, named.Table); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (d *Daemon) writeTable(ctx context.Context, sheet string, table *sheets.Table) error {
+	logger := logging.FromContext(ctx)
+	if err := d.writer.WriteTable(ctx, sheet, table); err != nil {
+		logger.WithError(err).Warnf("Failed to update %s table", sheet)
+		return err
+	}
+	logger.Infof("Successfully updated %s table", sheet)
+	return nil
+}
+
+// observe compares the merge requests with the previous iteration and
+// returns their snapshots and status transitions. Transitions come from the
+// history store when it is enabled and from the previous iteration in memory
+// otherwise. Nothing is stored until record is called with the snapshots.
+// initial is set when there is nothing to compare with yet, in which case
+// every merge request shows up as a new one.
+func (d *Daemon) observe(ctx context.Context, mergeRequestsByStudent map[string][]*mergeRequestTitle, now time.Time) ([]*history.Snapshot, []*history.Transition, bool, error) {
+	logger := logging.FromContext(ctx)
+	snapshots := make([]*history.Snapshot, 0)
+	for _, mrs := range mergeRequestsByStudent {
+		for _, mr := range mrs {
+			snapshots = append(snapshots, mr.snapshot(now))
+		}
+	}
+
+	var transitions []*history.Transition
+	var initial bool
+	if d.history != nil {
+		known, err := d.history.Known()
+		if err != nil {
+			return nil, nil, false, err
+		}
+		initial = known == 0
+
+		transitions, err = d.history.Pending(snapshots)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	} else {
+		initial = d.previousStatuses == nil
+		transitions = diffStatuses(d.previousStatuses, snapshots)
+	}
+
+	for _, transition := range transitions {
+		logger.WithFields(log.Fields{
+			"url":  transition.Url,
+			"from": transition.From,
+			"to":   transition.To,
+		}).Debugln("Merge request status changed")
+	}
+	logger.Infof("Observed %d status transitions", len(transitions))
+	return snapshots, transitions, initial, nil
+}
+
+// record stores the snapshots returned by observe, so the next iteration
+// compares with them.
+func (d *Daemon) record(snapshots []*history.Snapshot) error {
+	if snapshots == nil {
+		return nil
+	}
+	if d.history != nil {
+		_, err := d.history.Record(snapshots)
+		return err
+	}
+	d.previousStatuses = make(map[string]string, len(snapshots))
+	for _, snapshot := range snapshots {
+		d.previousStatuses[snapshot.Url] = snapshot.Status
+	}
+	return nil
+}
+
+func diffStatuses(previous map[string]string, snapshots []*history.Snapshot) []*history.Transition {
+	transitions := make([]*history.Transition, 0)
+	for _, snapshot := range snapshots {
+		from, found := previous[snapshot.Url]
+		if found && from == snapshot.Status {
+			continue
+		}
+		transitions = append(transitions, &history.Transition{
+			Url:     snapshot.Url,
+			Student: snapshot.Student,
+			Task:    snapshot.Task,
+			From:    from,
+			To:      snapshot.Status,
+			At:      snapshot.ObservedAt,
+		})
+	}
+	return transitions
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bigredeye/concurrency_watcher/internal/config"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+var (
+	Yellow     = parseHexColor("#ffd966")
+	DarkYellow = parseHexColor("#f1c232")
+	Orange     = parseHexColor("#f6b26b")
+	DarkOrange = parseHexColor("#e69138")
+)
+
+// Colors used for merge requests that have been waiting for a review
+// longer than the warn and alert thresholds respectively.
+var escalationColors = map[string][2]*sheets.Color{
+	statusPending:          {Yellow, DarkYellow},
+	statusProblemsResolved: {Orange, DarkOrange},
+}
+
+type turnaroundTracker struct {
+	now        time.Time
+	warnAfter  time.Duration
+	alertAfter time.Duration
+}
+
+func newTurnaroundTracker(config *config.Config, now time.Time) *turnaroundTracker {
+	return &turnaroundTracker{
+		now:        now,
+		warnAfter:  config.ReviewWarnAfter,
+		alertAfter: config.ReviewAlertAfter,
+	}
+}
+
+func (t *turnaroundTracker) classify(mr *mergeRequestTitle) (string, *sheets.Color) {
+	text, color := classifyMergeRequestStatus(mr)
+
+	since, waiting := mr.waitingSince()
+	if !waiting {
+		return text, color
+	}
+
+	age := t.now.Sub(since)
+	if escalated := t.escalate(mr.status(), age); escalated != nil {
+		color = escalated
+	}
+	return fmt.Sprintf("%s %s", text, formatAge(age)), color
+}
+
+func (t *turnaroundTracker) escalate(status string, age time.Duration) *sheets.Color {
+	colors, found := escalationColors[status]
+	if !found {
+		return nil
+	}
+
+	switch {
+	case t.alertAfter > 0 && age >= t.alertAfter:
+		return colors[1]
+	case t.warnAfter > 0 && age >= t.warnAfter:
+		return colors[0]
+	default:
+		return nil
+	}
+}
+
+// waitingSince reports when the merge request entered its current state if
+// it is waiting for a reviewer.
+func (mr *mergeRequestTitle) waitingSince() (time.Time, bool) {
+	switch mr.status() {
+	case statusPending:
+		return mr.createdAt, !mr.createdAt.IsZero()
+	case statusProblemsResolved:
+		since := mr.lastResolvedAt
+		if since.IsZero() {
+			since = latest(mr.createdAt, mr.lastNoteAt)
+		}
+		return since, !since.IsZero()
+	default:
+		return time.Time{}, false
+	}
+}
+
+func formatAge(age time.Duration) string {
+	switch {
+	case age >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(age/(24*time.Hour)))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age/time.Hour))
+	default:
+		return fmt.Sprintf("%dm", int(age/time.Minute))
+	}
+}
+
+func parseTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func latest(lhs time.Time, rhs time.Time) time.Time {
+	if rhs.After(lhs) {
+		return rhs
+	}
+	return lhs
+}
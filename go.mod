@@ -3,12 +3,14 @@ module github.com/bigredeye/concurrency_watcher
 go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/joho/godotenv v1.3.0
 	github.com/machinebox/graphql v0.2.2
 	github.com/matryer/is v1.4.0 // indirect
+	github.com/prometheus/client_golang v1.11.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/viper v1.7.1
-	github.com/stretchr/testify v1.4.0 // indirect
+	go.etcd.io/bbolt v1.3.6
 	google.golang.org/api v0.13.0
-	gopkg.in/yaml.v2 v2.2.4
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -8,39 +11,227 @@ import (
 	"github.com/spf13/viper"
 )
 
+const DefaultCourse = "default"
+
+// MetricsDisabled as METRICS_ADDRESS turns the metrics server off. An empty
+// address in the config file does the same, but an empty environment
+// variable is ignored by viper and leaves the default in place.
+const MetricsDisabled = "off"
+
+// Config fields tagged secret may also be given as a path in *_FILE or a
+// shell command printing the value in *_COMMAND, see resolveSecrets.
 type Config struct {
+	// CourseName is set on configs returned by CourseConfigs.
+	CourseName string   `mapstructure:"-"`
+	Courses    []Course `mapstructure:"courses"`
+	// secretSources maps keys of secrets read from files or commands to
+	// where they came from.
+	secretSources map[string]string
+
 	GoogleCredentialsPath string        `mapstructure:"google_credentials_path"`
 	GoogleSpreadsheetId   string        `mapstructure:"google_spreadsheet_id"`
-	GitLabToken           string        `mapstructure:"gitlab_token"`
+	GitLabUrl             string        `mapstructure:"gitlab_url"`
+	GitLabToken           string        `mapstructure:"gitlab_token" secret:"true"`
 	GitLabGroup           string        `mapstructure:"gitlab_group"`
 	GitLabLabel           string        `mapstructure:"gitlab_label"`
 	IterationInterval     time.Duration `mapstructure:"iteration_interval"`
 	DeadlinesUrl          string        `mapstructure:"deadlines_url"`
-	EligibleReviewers     string        `mapstructure:"eligible_reviewers"`
+	ReviewersPath         string        `mapstructure:"reviewers_path"`
+	ReviewWarnAfter       time.Duration `mapstructure:"review_warn_after"`
+	ReviewAlertAfter      time.Duration `mapstructure:"review_alert_after"`
+	ShutdownGracePeriod   time.Duration `mapstructure:"shutdown_grace_period"`
+	MetricsAddress        string        `mapstructure:"metrics_address"`
+	HistoryPath           string        `mapstructure:"history_path"`
+	RosterPath            string        `mapstructure:"roster_path"`
+	EventsMaxRows         int           `mapstructure:"events_max_rows"`
+	SheetsUpdateMode      string        `mapstructure:"sheets_update_mode"`
+	SheetsCreateMissing   bool          `mapstructure:"sheets_create_missing"`
+	SheetsFreezeHeader    bool          `mapstructure:"sheets_freeze_header"`
+	SheetsProtectHeader   bool          `mapstructure:"sheets_protect_header"`
+	// SheetLayout is keyed by sheet names in lower case, as viper does
+	// not preserve the case of keys.
+	SheetLayout           map[string]SheetLayout `mapstructure:"sheet_layout"`
+	SnapshotMaxAge        time.Duration          `mapstructure:"snapshot_max_age"`
+	SnapshotSweepInterval time.Duration          `mapstructure:"snapshot_sweep_interval"`
+	Reports               []string               `mapstructure:"reports"`
+	DisabledReports       []string               `mapstructure:"disabled_reports"`
+
+	NotifyWebhookUrl       string `mapstructure:"notify_webhook_url"`
+	NotifyTelegramUrl      string `mapstructure:"notify_telegram_url"`
+	NotifyTelegramToken    string `mapstructure:"notify_telegram_token" secret:"true"`
+	NotifySmtpAddress      string `mapstructure:"notify_smtp_address"`
+	NotifySmtpFrom         string `mapstructure:"notify_smtp_from"`
+	NotifySmtpUsername     string `mapstructure:"notify_smtp_username"`
+	NotifySmtpPassword     string `mapstructure:"notify_smtp_password" secret:"true"`
+	NotifyStudentTemplate  string `mapstructure:"notify_student_template"`
+	NotifyReviewerTemplate string `mapstructure:"notify_reviewer_template"`
+}
+
+// Course overrides the top-level fields for one course. Empty fields are
+// inherited from the top level.
+type Course struct {
+	Name                string        `mapstructure:"name"`
+	GitLabUrl           string        `mapstructure:"gitlab_url"`
+	GitLabToken         string        `mapstructure:"gitlab_token" secret:"true"`
+	GitLabTokenFile     string        `mapstructure:"gitlab_token_file"`
+	GitLabTokenCommand  string        `mapstructure:"gitlab_token_command"`
+	GitLabGroup         string        `mapstructure:"gitlab_group"`
+	GitLabLabel         string        `mapstructure:"gitlab_label"`
+	GoogleSpreadsheetId string        `mapstructure:"google_spreadsheet_id"`
+	DeadlinesUrl        string        `mapstructure:"deadlines_url"`
+	ReviewersPath       string        `mapstructure:"reviewers_path"`
+	RosterPath          string        `mapstructure:"roster_path"`
+	HistoryPath         string        `mapstructure:"history_path"`
+	IterationInterval   time.Duration `mapstructure:"iteration_interval"`
+}
+
+// SheetLayout sets where and how a missing sheet is created.
+type SheetLayout struct {
+	// Position is 1-based, zero keeps the default order.
+	Position int    `mapstructure:"position"`
+	TabColor string `mapstructure:"tab_color"`
+}
+
+// CourseConfigs returns a config per course. Without a courses section
+// the top-level fields describe a single course named DefaultCourse.
+// Courses that share the top-level history path get a file of their own,
+// since the history database can only be opened by one course at a time.
+func (c *Config) CourseConfigs() []*Config {
+	if len(c.Courses) == 0 {
+		conf := *c
+		conf.CourseName = DefaultCourse
+		return []*Config{&conf}
+	}
+
+	res := make([]*Config, 0, len(c.Courses))
+	for _, course := range c.Courses {
+		conf := *c
+		conf.Courses = nil
+		conf.CourseName = course.Name
+		override(&conf.GitLabUrl, course.GitLabUrl)
+		override(&conf.GitLabToken, course.GitLabToken)
+		override(&conf.GitLabGroup, course.GitLabGroup)
+		override(&conf.GitLabLabel, course.GitLabLabel)
+		override(&conf.GoogleSpreadsheetId, course.GoogleSpreadsheetId)
+		override(&conf.DeadlinesUrl, course.DeadlinesUrl)
+		override(&conf.ReviewersPath, course.ReviewersPath)
+		override(&conf.RosterPath, course.RosterPath)
+		if course.IterationInterval != 0 {
+			conf.IterationInterval = course.IterationInterval
+		}
+		if course.HistoryPath != "" {
+			conf.HistoryPath = course.HistoryPath
+		} else if conf.HistoryPath != "" && len(c.Courses) > 1 {
+			ext := filepath.Ext(conf.HistoryPath)
+			conf.HistoryPath = strings.TrimSuffix(conf.HistoryPath, ext) + "-" + course.Name + ext
+		}
+		res = append(res, &conf)
+	}
+	return res
+}
+
+func override(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+var defaults = map[string]interface{}{
+	"GITLAB_URL":              "https://gitlab.com",
+	"GITLAB_LABEL":            "hse",
+	"REVIEWERS_PATH":          "reviewers.yml",
+	"REVIEW_WARN_AFTER":       3 * 24 * time.Hour,
+	"REVIEW_ALERT_AFTER":      7 * 24 * time.Hour,
+	"SHUTDOWN_GRACE_PERIOD":   30 * time.Second,
+	"METRICS_ADDRESS":         ":8080",
+	"HISTORY_PATH":            "history.db",
+	"EVENTS_MAX_ROWS":         5000,
+	"SHEETS_UPDATE_MODE":      "diff",
+	"SHEETS_CREATE_MISSING":   true,
+	"SHEETS_FREEZE_HEADER":    true,
+	"SNAPSHOT_MAX_AGE":        30 * time.Minute,
+	"SNAPSHOT_SWEEP_INTERVAL": time.Hour,
 }
 
 func LoadConfig() (*Config, error) {
+	viper.SetConfigName("config")
+	viper.AddConfigPath(".")
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		viper.SetConfigFile(path)
+	}
+
 	viper.BindEnv("GOOGLE_CREDENTIALS_PATH")
 	viper.BindEnv("GOOGLE_SPREADSHEET_ID")
+	viper.BindEnv("GITLAB_URL")
 	viper.BindEnv("GITLAB_TOKEN")
 	viper.BindEnv("GITLAB_GROUP")
 	viper.BindEnv("GITLAB_LABEL")
 	viper.BindEnv("ITERATION_INTERVAL")
 	viper.BindEnv("DEADLINES_URL")
-	viper.BindEnv("ELIGIBLE_REVIEWERS")
+	viper.BindEnv("REVIEWERS_PATH")
+	viper.BindEnv("REVIEW_WARN_AFTER")
+	viper.BindEnv("REVIEW_ALERT_AFTER")
+	viper.BindEnv("SHUTDOWN_GRACE_PERIOD")
+	viper.BindEnv("METRICS_ADDRESS")
+	viper.BindEnv("HISTORY_PATH")
+	viper.BindEnv("ROSTER_PATH")
+	viper.BindEnv("EVENTS_MAX_ROWS")
+	viper.BindEnv("SHEETS_UPDATE_MODE")
+	viper.BindEnv("SHEETS_CREATE_MISSING")
+	viper.BindEnv("SHEETS_FREEZE_HEADER")
+	viper.BindEnv("SHEETS_PROTECT_HEADER")
+	viper.BindEnv("SNAPSHOT_MAX_AGE")
+	viper.BindEnv("SNAPSHOT_SWEEP_INTERVAL")
+	viper.BindEnv("REPORTS")
+	viper.BindEnv("DISABLED_REPORTS")
+	viper.BindEnv("NOTIFY_WEBHOOK_URL")
+	viper.BindEnv("NOTIFY_TELEGRAM_URL")
+	viper.BindEnv("NOTIFY_TELEGRAM_TOKEN")
+	viper.BindEnv("NOTIFY_SMTP_ADDRESS")
+	viper.BindEnv("NOTIFY_SMTP_FROM")
+	viper.BindEnv("NOTIFY_SMTP_USERNAME")
+	viper.BindEnv("NOTIFY_SMTP_PASSWORD")
+	viper.BindEnv("NOTIFY_STUDENT_TEMPLATE")
+	viper.BindEnv("NOTIFY_REVIEWER_TEMPLATE")
 
+	for _, key := range secretKeys() {
+		viper.BindEnv(key + "_FILE")
+		viper.BindEnv(key + "_COMMAND")
+	}
+
+	for key, value := range defaults {
+		viper.SetDefault(key, value)
+	}
+
+	if err := readInConfig(); err != nil {
+		return nil, err
+	}
+	return decode()
+}
+
+func readInConfig() error {
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
 			log.Warn("Config file not found")
 		} else {
-			return nil, err
+			return err
 		}
 	}
+	return nil
+}
 
+func decode() (*Config, error) {
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, err
 	}
 
+	if strings.EqualFold(config.MetricsAddress, MetricsDisabled) {
+		config.MetricsAddress = ""
+	}
+
+	if err := config.resolveSecrets(); err != nil {
+		return nil, err
+	}
 	return &config, nil
 }
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadConfigMetricsAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		env  *string
+		want string
+	}{
+		{
+			name: "default",
+			want: ":8080",
+		},
+		{
+			name: "address",
+			env:  stringPtr("127.0.0.1:9090"),
+			want: "127.0.0.1:9090",
+		},
+		{
+			name: "off",
+			env:  stringPtr("off"),
+			want: "",
+		},
+		{
+			name: "empty is ignored",
+			env:  stringPtr(""),
+			want: ":8080",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			// Setenv restores the variables once the test is over.
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("METRICS_ADDRESS", "")
+			if tt.env != nil {
+				t.Setenv("METRICS_ADDRESS", *tt.env)
+			} else {
+				os.Unsetenv("METRICS_ADDRESS")
+			}
+
+			conf, err := LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if conf.MetricsAddress != tt.want {
+				t.Errorf("MetricsAddress = %q, want %q", conf.MetricsAddress, tt.want)
+			}
+		})
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
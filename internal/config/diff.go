@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Diff describes how the effective config of every course changed. Values
+// of secret fields are never printed.
+func Diff(old *Config, new *Config) []string {
+	oldCourses := coursesByName(old)
+	newCourses := coursesByName(new)
+	prefixed := len(oldCourses) > 1 || len(newCourses) > 1
+
+	names := make([]string, 0)
+	for name := range oldCourses {
+		names = append(names, name)
+	}
+	for name := range newCourses {
+		if _, found := oldCourses[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	res := make([]string, 0)
+	for _, name := range names {
+		before, after := oldCourses[name], newCourses[name]
+		switch {
+		case before == nil:
+			res = append(res, fmt.Sprintf("course %s added", name))
+		case after == nil:
+			res = append(res, fmt.Sprintf("course %s removed", name))
+		default:
+			prefix := ""
+			if prefixed {
+				prefix = "courses[" + name + "]."
+			}
+			res = append(res, diffFields(prefix, before, after)...)
+		}
+	}
+	return res
+}
+
+func coursesByName(c *Config) map[string]*Config {
+	res := make(map[string]*Config)
+	for _, course := range c.CourseConfigs() {
+		res[course.CourseName] = course
+	}
+	return res
+}
+
+func diffFields(prefix string, old *Config, new *Config) []string {
+	res := make([]string, 0)
+	oldValue, newValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		key := field.Tag.Get("mapstructure")
+		if field.PkgPath != "" || key == "" || key == "-" || key == "courses" {
+			continue
+		}
+
+		before, after := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		if field.Tag.Get("secret") == "true" {
+			res = append(res, fmt.Sprintf("%s%s changed", prefix, key))
+			continue
+		}
+		res = append(res, fmt.Sprintf("%s%s: %v -> %v", prefix, key, before, after))
+	}
+	return res
+}
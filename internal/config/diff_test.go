@@ -0,0 +1,75 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	base := func() *Config {
+		return &Config{
+			GitLabToken:       "token",
+			GitLabGroup:       "group",
+			IterationInterval: time.Minute,
+			secretSources:     map[string]string{"GITLAB_TOKEN": "file /run/token"},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			change: func(c *Config) {},
+			want:   []string{},
+		},
+		{
+			name:   "unexported field",
+			change: func(c *Config) { c.secretSources = map[string]string{"GITLAB_TOKEN": "command cat"} },
+			want:   []string{},
+		},
+		{
+			name:   "plain field",
+			change: func(c *Config) { c.GitLabGroup = "other" },
+			want:   []string{"gitlab_group: group -> other"},
+		},
+		{
+			name:   "secret field",
+			change: func(c *Config) { c.GitLabToken = "new" },
+			want:   []string{"gitlab_token changed"},
+		},
+		{
+			name: "course added",
+			change: func(c *Config) {
+				c.Courses = []Course{{Name: "a"}, {Name: "b"}}
+			},
+			want: []string{"course a added", "course b added", "course default removed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := base()
+			tt.change(next)
+			if got := Diff(base(), next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffCourseOverride(t *testing.T) {
+	old := &Config{GitLabUrl: "https://gitlab.com", GitLabGroup: "group", Courses: []Course{{Name: "a"}, {Name: "b"}}}
+	next := &Config{GitLabUrl: "https://gitlab.com", GitLabGroup: "group", Courses: []Course{{Name: "a", GitLabUrl: "https://gitlab.example.com", GitLabGroup: "other"}, {Name: "b"}}}
+
+	want := []string{
+		"courses[a].gitlab_url: https://gitlab.com -> https://gitlab.example.com",
+		"courses[a].gitlab_group: group -> other",
+	}
+	if got := Diff(old, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const redacted = "<redacted>"
+
+// Entry is an effective config value together with where it came from:
+// env, config file, secret file, command or default.
+type Entry struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Entries lists top-level values followed by course overrides. Values of
+// secret fields are redacted.
+func (c *Config) Entries() []Entry {
+	res := make([]Entry, 0)
+
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" || key == "-" || key == "courses" {
+			continue
+		}
+
+		res = append(res, Entry{
+			Key:    key,
+			Value:  formatValue(v.Field(i), field.Tag.Get("secret") == "true"),
+			Source: c.source(key),
+		})
+	}
+
+	for _, course := range c.Courses {
+		v := reflect.ValueOf(course)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key := field.Tag.Get("mapstructure")
+			if key == "name" || v.Field(i).IsZero() {
+				continue
+			}
+
+			key = fmt.Sprintf("courses[%s].%s", course.Name, key)
+			source, found := c.secretSources[key]
+			if !found {
+				source = "config file"
+			}
+			res = append(res, Entry{
+				Key:    key,
+				Value:  formatValue(v.Field(i), field.Tag.Get("secret") == "true"),
+				Source: source,
+			})
+		}
+	}
+	return res
+}
+
+func formatValue(value reflect.Value, secret bool) string {
+	if value.IsZero() {
+		return ""
+	}
+	if secret {
+		return redacted
+	}
+	if list, ok := value.Interface().([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// source mirrors viper precedence: env, then the config file, then
+// defaults.
+func (c *Config) source(key string) string {
+	if source, found := c.secretSources[key]; found {
+		return source
+	}
+	if os.Getenv(strings.ToUpper(key)) != "" {
+		return "env"
+	}
+	if viper.InConfig(key) {
+		return "config file"
+	}
+	if _, found := defaults[strings.ToUpper(key)]; found {
+		return "default"
+	}
+	return "unset"
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// viper is not safe for concurrent use and reloads may come both from the
+// file watcher and from SIGHUP.
+var reloadMu sync.Mutex
+
+// Reload re-reads the config file and the environment. The result is not
+// validated.
+func Reload() (*Config, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if err := readInConfig(); err != nil {
+		return nil, err
+	}
+	return decode()
+}
+
+// Watcher calls back when the config file changes. viper.WatchConfig is
+// not used because it re-reads the file on its own goroutine, which would
+// race with reloads on SIGHUP.
+type Watcher struct {
+	watcher *fsnotify.Watcher
+}
+
+// Watch starts watching the config file. It returns a no-op watcher if no
+// config file was found.
+func Watch(onChange func()) (*Watcher, error) {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return &Watcher{}, nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(path) {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Warnln("Config file watcher failed")
+			}
+		}
+	}()
+
+	return &Watcher{watcher: watcher}, nil
+}
+
+func (w *Watcher) Close() error {
+	if w.watcher == nil {
+		return nil
+	}
+	return w.watcher.Close()
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const secretCommandTimeout = 10 * time.Second
+
+// secretKeys returns environment variable names of fields tagged secret.
+func secretKeys() []string {
+	res := make([]string, 0)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("secret") == "true" {
+			res = append(res, strings.ToUpper(t.Field(i).Tag.Get("mapstructure")))
+		}
+	}
+	return res
+}
+
+// resolveSecrets fills empty secret fields from the file named in *_FILE
+// or from the output of the shell command in *_COMMAND. A value given
+// directly takes precedence over the file, and the file over the command.
+func (c *Config) resolveSecrets() error {
+	c.secretSources = make(map[string]string)
+
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Tag.Get("secret") != "true" || v.Field(i).String() != "" {
+			continue
+		}
+
+		key := field.Tag.Get("mapstructure")
+		value, source, err := readSecret(strings.ToUpper(key), viper.GetString(key+"_file"), viper.GetString(key+"_command"))
+		if err != nil {
+			return err
+		}
+		if source != "" {
+			v.Field(i).SetString(value)
+			c.secretSources[key] = source
+		}
+	}
+
+	for i := range c.Courses {
+		course := &c.Courses[i]
+		if course.GitLabToken != "" {
+			continue
+		}
+
+		key := fmt.Sprintf("courses[%s].gitlab_token", course.Name)
+		value, source, err := readSecret(key, course.GitLabTokenFile, course.GitLabTokenCommand)
+		if err != nil {
+			return err
+		}
+		if source != "" {
+			course.GitLabToken = value
+			c.secretSources[key] = source
+		}
+	}
+	return nil
+}
+
+// readSecret returns the secret and a description of where it came from,
+// or an empty source if neither path nor command is set.
+func readSecret(name string, path string, command string) (string, string, error) {
+	switch {
+	case path != "":
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("Failed to read %s from file: %w", name, err)
+		}
+		return strings.TrimSpace(string(data)), "file " + path, nil
+
+	case command != "":
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+
+		out, err := exec.CommandContext(ctx, "sh", "-c", command).Output()
+		if err != nil {
+			return "", "", fmt.Errorf("Failed to get %s from command: %w", name, err)
+		}
+		return strings.TrimSpace(string(out)), "command", nil
+	}
+	return "", "", nil
+}
@@ -0,0 +1,212 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MinIterationInterval keeps a misconfigured interval from turning the
+// daemon into a busy loop hammering the APIs.
+const MinIterationInterval = 10 * time.Second
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ValidationError lists every problem found in the config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "Invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) required(name string, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.addf("%s is required", name)
+		return false
+	}
+	return true
+}
+
+func (v *validator) url(name string, value string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		v.addf("%s %q is not a valid url: %v", name, value, err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		v.addf("%s %q must be an http or https url", name, value)
+		return
+	}
+	if u.Host == "" {
+		v.addf("%s %q has no host", name, value)
+	}
+}
+
+func (v *validator) address(name string, value string) {
+	if _, _, err := net.SplitHostPort(value); err != nil {
+		v.addf("%s %q is not a host:port address: %v", name, value, err)
+	}
+}
+
+func (v *validator) file(name string, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		v.addf("%s: %v", name, err)
+		return
+	}
+	if info.IsDir() {
+		v.addf("%s %q is a directory", name, path)
+	}
+}
+
+// credentials checks that the file is a Google credentials JSON of a
+// known type without contacting Google.
+func (v *validator) credentials(name string, path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		v.addf("%s: %v", name, err)
+		return
+	}
+
+	var credentials struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		v.addf("%s %q is not a JSON credentials file: %v", name, path, err)
+		return
+	}
+	switch credentials.Type {
+	case "service_account", "authorized_user", "external_account":
+	default:
+		v.addf("%s %q has unsupported credentials type %q", name, path, credentials.Type)
+	}
+}
+
+func (v *validator) positive(name string, value time.Duration) {
+	if value <= 0 {
+		v.addf("%s must be positive, got %s", name, value)
+	}
+}
+
+// Validate checks the config without any network calls and reports all
+// problems at once.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	if v.required("GOOGLE_CREDENTIALS_PATH", c.GoogleCredentialsPath) {
+		v.credentials("GOOGLE_CREDENTIALS_PATH", c.GoogleCredentialsPath)
+	}
+
+	v.positive("REVIEW_WARN_AFTER", c.ReviewWarnAfter)
+	v.positive("REVIEW_ALERT_AFTER", c.ReviewAlertAfter)
+	if c.ReviewAlertAfter < c.ReviewWarnAfter {
+		v.addf("REVIEW_ALERT_AFTER (%s) must not be less than REVIEW_WARN_AFTER (%s)", c.ReviewAlertAfter, c.ReviewWarnAfter)
+	}
+	if c.ShutdownGracePeriod < 0 {
+		v.addf("SHUTDOWN_GRACE_PERIOD must not be negative, got %s", c.ShutdownGracePeriod)
+	}
+	if c.EventsMaxRows < 0 {
+		v.addf("EVENTS_MAX_ROWS must not be negative, got %d", c.EventsMaxRows)
+	}
+	if c.SheetsUpdateMode != "diff" && c.SheetsUpdateMode != "snapshot" {
+		v.addf("SHEETS_UPDATE_MODE must be diff or snapshot, got %q", c.SheetsUpdateMode)
+	}
+	layoutNames := make([]string, 0, len(c.SheetLayout))
+	for name := range c.SheetLayout {
+		layoutNames = append(layoutNames, name)
+	}
+	sort.Strings(layoutNames)
+	for _, name := range layoutNames {
+		layout := c.SheetLayout[name]
+		if layout.Position < 0 {
+			v.addf("sheet_layout.%s.position must not be negative, got %d", name, layout.Position)
+		}
+		if layout.TabColor != "" && !colorPattern.MatchString(layout.TabColor) {
+			v.addf("sheet_layout.%s.tab_color %q must be in #rrggbb format", name, layout.TabColor)
+		}
+	}
+	v.positive("SNAPSHOT_MAX_AGE", c.SnapshotMaxAge)
+	v.positive("SNAPSHOT_SWEEP_INTERVAL", c.SnapshotSweepInterval)
+	if c.MetricsAddress != "" {
+		v.address("METRICS_ADDRESS", c.MetricsAddress)
+	}
+
+	if c.NotifyWebhookUrl != "" {
+		v.url("NOTIFY_WEBHOOK_URL", c.NotifyWebhookUrl)
+	}
+	if c.NotifyTelegramToken != "" && c.NotifyTelegramUrl != "" {
+		v.url("NOTIFY_TELEGRAM_URL", c.NotifyTelegramUrl)
+	}
+	if c.NotifySmtpAddress != "" {
+		v.address("NOTIFY_SMTP_ADDRESS", c.NotifySmtpAddress)
+		v.required("NOTIFY_SMTP_FROM", c.NotifySmtpFrom)
+	}
+
+	names := make(map[string]bool)
+	for i, course := range c.Courses {
+		if course.Name == "" {
+			v.addf("courses[%d]: name is required", i)
+		} else if names[course.Name] {
+			v.addf("courses[%d]: duplicate name %q", i, course.Name)
+		}
+		names[course.Name] = true
+	}
+
+	for _, course := range c.CourseConfigs() {
+		course.validateCourse(v, len(c.Courses) > 0)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validateCourse checks fields that courses may override. Problems are
+// reported with file keys of the course if it comes from the courses
+// section and with environment variable names otherwise.
+func (c *Config) validateCourse(v *validator, fromFile bool) {
+	name := func(key string) string {
+		if fromFile {
+			return fmt.Sprintf("courses[%s].%s", c.CourseName, strings.ToLower(key))
+		}
+		return key
+	}
+
+	v.required(name("GOOGLE_SPREADSHEET_ID"), c.GoogleSpreadsheetId)
+	if v.required(name("GITLAB_URL"), c.GitLabUrl) {
+		v.url(name("GITLAB_URL"), c.GitLabUrl)
+	}
+	v.required(name("GITLAB_TOKEN"), c.GitLabToken)
+	v.required(name("GITLAB_GROUP"), c.GitLabGroup)
+	v.required(name("GITLAB_LABEL"), c.GitLabLabel)
+	if v.required(name("DEADLINES_URL"), c.DeadlinesUrl) {
+		v.url(name("DEADLINES_URL"), c.DeadlinesUrl)
+	}
+	if v.required(name("REVIEWERS_PATH"), c.ReviewersPath) {
+		v.file(name("REVIEWERS_PATH"), c.ReviewersPath)
+	}
+	if c.RosterPath != "" {
+		v.file(name("ROSTER_PATH"), c.RosterPath)
+	}
+	if c.IterationInterval < MinIterationInterval {
+		v.addf("%s must be at least %s, got %s", name("ITERATION_INTERVAL"), MinIterationInterval, c.IterationInterval)
+	}
+}
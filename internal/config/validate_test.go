@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(credentials, []byte(`{"type":"service_account"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reviewers := filepath.Join(dir, "reviewers.yml")
+	if err := ioutil.WriteFile(reviewers, []byte("reviewers: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Config{
+		GoogleCredentialsPath: credentials,
+		GoogleSpreadsheetId:   "spreadsheet",
+		GitLabUrl:             "https://gitlab.com",
+		GitLabToken:           "token",
+		GitLabGroup:           "group",
+		GitLabLabel:           "hse",
+		IterationInterval:     time.Minute,
+		DeadlinesUrl:          "https://example.com/deadlines.yml",
+		ReviewersPath:         reviewers,
+		ReviewWarnAfter:       3 * 24 * time.Hour,
+		ReviewAlertAfter:      7 * 24 * time.Hour,
+		MetricsAddress:        ":8080",
+		SheetsUpdateMode:      "diff",
+		SnapshotMaxAge:        30 * time.Minute,
+		SnapshotSweepInterval: time.Hour,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   []string
+	}{
+		{
+			name:   "valid",
+			change: func(c *Config) {},
+		},
+		{
+			name:   "metrics disabled",
+			change: func(c *Config) { c.MetricsAddress = "" },
+		},
+		{
+			name:   "missing token",
+			change: func(c *Config) { c.GitLabToken = " " },
+			want:   []string{"GITLAB_TOKEN is required"},
+		},
+		{
+			name:   "gitlab url without scheme",
+			change: func(c *Config) { c.GitLabUrl = "gitlab.example.com" },
+			want:   []string{`GITLAB_URL "gitlab.example.com" must be an http or https url`},
+		},
+		{
+			name:   "short interval",
+			change: func(c *Config) { c.IterationInterval = time.Second },
+			want:   []string{"ITERATION_INTERVAL must be at least 10s, got 1s"},
+		},
+		{
+			name: "alert before warning",
+			change: func(c *Config) {
+				c.ReviewAlertAfter = time.Hour
+				c.ReviewWarnAfter = 2 * time.Hour
+			},
+			want: []string{"REVIEW_ALERT_AFTER (1h0m0s) must not be less than REVIEW_WARN_AFTER (2h0m0s)"},
+		},
+		{
+			name:   "unknown update mode",
+			change: func(c *Config) { c.SheetsUpdateMode = "overwrite" },
+			want:   []string{`SHEETS_UPDATE_MODE must be diff or snapshot, got "overwrite"`},
+		},
+		{
+			name: "sheet layout",
+			change: func(c *Config) {
+				c.SheetLayout = map[string]SheetLayout{
+					"reviews": {Position: -1},
+					"events":  {TabColor: "red"},
+				}
+			},
+			want: []string{
+				`sheet_layout.events.tab_color "red" must be in #rrggbb format`,
+				"sheet_layout.reviews.position must not be negative, got -1",
+			},
+		},
+		{
+			name:   "snapshot age",
+			change: func(c *Config) { c.SnapshotMaxAge = 0 },
+			want:   []string{"SNAPSHOT_MAX_AGE must be positive, got 0s"},
+		},
+		{
+			name:   "smtp without sender",
+			change: func(c *Config) { c.NotifySmtpAddress = "smtp.example.com:587" },
+			want:   []string{"NOTIFY_SMTP_FROM is required"},
+		},
+		{
+			name: "courses",
+			change: func(c *Config) {
+				c.Courses = []Course{{Name: "os"}, {Name: "os"}, {Name: "db", GitLabUrl: "ftp://gitlab.example.com", IterationInterval: time.Second}}
+			},
+			want: []string{
+				`courses[1]: duplicate name "os"`,
+				`courses[db].gitlab_url "ftp://gitlab.example.com" must be an http or https url`,
+				"courses[db].iteration_interval must be at least 10s, got 1s",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig(t)
+			tt.change(conf)
+
+			err := conf.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+			if !reflect.DeepEqual(validationErr.Problems, tt.want) {
+				t.Errorf("got problems %q, want %q", validationErr.Problems, tt.want)
+			}
+		})
+	}
+}
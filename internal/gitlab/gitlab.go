@@ -2,10 +2,13 @@ package gitlab
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/machinebox/graphql"
 
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
 	"github.com/bigredeye/concurrency_watcher/internal/types"
 )
 
@@ -21,40 +24,61 @@ func NewClient(url string, token string) (*Client, error) {
 	}, nil
 }
 
+const mergeRequestFragment = `fragment MergeRequestFields on MergeRequest {
+  title
+  author {
+    name
+    username
+  }
+  createdAt
+  mergeStatus
+  conflicts
+  shouldBeRebased
+  approvedBy {
+    nodes {
+      username
+    }
+  }
+  headPipeline {
+    status
+  }
+  webUrl
+  discussions {
+    nodes {
+      resolvable
+      resolved
+      resolvedAt
+      resolvedBy {
+        username
+      }
+      notes {
+        nodes {
+          author {
+            username
+          }
+          createdAt
+          system
+          body
+        }
+      }
+    }
+  }
+}`
+
 type GroupRes struct {
 	Group types.Group `json:"group"`
 }
 
-func (c *Client) ListGroupRequests(groupPath string) (*types.Group, error) {
-	req := graphql.NewRequest(`query($groupPath: ID!, $labels: [String!], $cursor: String!) {
+func (c *Client) ListGroupRequests(ctx context.Context, groupPath string, label string) (*types.Group, error) {
+	req := graphql.NewRequest(mergeRequestFragment + `
+query($groupPath: ID!, $labels: [String!], $cursor: String!) {
   group(fullPath: $groupPath) {
     id
     name
     mergeRequests(labels: $labels, first: 100, sort: CREATED_DESC, after: $cursor) {
       count
       nodes {
-        title
-        author {
-          name
-          username
-        }
-        createdAt
-        mergeStatus
-        approvedBy {
-          nodes {
-            username
-          }
-        }
-        headPipeline {
-          status
-        }
-        webUrl
-        discussions {
-          nodes {
-            resolvable
-            resolved
-          }
-        }
+        ...MergeRequestFields
       }
       pageInfo {
         endCursor
@@ -65,17 +89,18 @@ func (c *Client) ListGroupRequests(groupPath string) (*types.Group, error) {
 }`)
 
 	req.Var("groupPath", groupPath)
-	req.Var("labels", "hse")
+	req.Var("labels", label)
 	req.Var("cursor", "")
 
 	req.Header.Set("Authorization", fmt.Sprint("Bearer ", c.token))
 
 	var group GroupRes
 	for {
-		ctx := context.Background()
-
 		var res GroupRes
-		if err := c.client.Run(ctx, req, &res); err != nil {
+		start := time.Now()
+		err := c.client.Run(ctx, req, &res)
+		metrics.ObserveRequest(ctx, metrics.ServiceGitLab, "list_group_merge_requests", start, err)
+		if err != nil {
 			return nil, err
 		}
 
@@ -94,3 +119,66 @@ func (c *Client) ListGroupRequests(groupPath string) (*types.Group, error) {
 
 	return &group.Group, nil
 }
+
+type ProjectRes struct {
+	Project *struct {
+		MergeRequest *types.MergeRequest `json:"mergeRequest"`
+	} `json:"project"`
+}
+
+func (c *Client) GetMergeRequest(ctx context.Context, projectPath string, iid string) (*types.MergeRequest, error) {
+	req := graphql.NewRequest(mergeRequestFragment + `
+query($projectPath: ID!, $iid: String!) {
+  project(fullPath: $projectPath) {
+    mergeRequest(iid: $iid) {
+      ...MergeRequestFields
+    }
+  }
+}`)
+
+	req.Var("projectPath", projectPath)
+	req.Var("iid", iid)
+
+	req.Header.Set("Authorization", fmt.Sprint("Bearer ", c.token))
+
+	var res ProjectRes
+	start := time.Now()
+	err := c.client.Run(ctx, req, &res)
+	metrics.ObserveRequest(ctx, metrics.ServiceGitLab, "get_merge_request", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Project == nil || res.Project.MergeRequest == nil {
+		return nil, fmt.Errorf("Merge request %s!%s not found", projectPath, iid)
+	}
+	return res.Project.MergeRequest, nil
+}
+
+type CurrentUserRes struct {
+	CurrentUser *types.User `json:"currentUser"`
+}
+
+func (c *Client) CurrentUser(ctx context.Context) (*types.User, error) {
+	req := graphql.NewRequest(`query {
+  currentUser {
+    name
+    username
+  }
+}`)
+
+	req.Header.Set("Authorization", fmt.Sprint("Bearer ", c.token))
+
+	var res CurrentUserRes
+	start := time.Now()
+	err := c.client.Run(ctx, req, &res)
+	metrics.ObserveRequest(ctx, metrics.ServiceGitLab, "current_user", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.CurrentUser == nil {
+		return nil, errors.New("Token is not associated with a user")
+	}
+	return res.CurrentUser, nil
+}
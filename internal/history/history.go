@@ -0,0 +1,343 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	latestBucket      = []byte("latest")
+	snapshotsBucket   = []byte("snapshots")
+	transitionsBucket = []byte("transitions")
+	timelineBucket    = []byte("timeline")
+)
+
+// Snapshot is the observed state of a merge request.
+type Snapshot struct {
+	Url             string    `json:"url"`
+	Student         string    `json:"student"`
+	Task            string    `json:"task"`
+	Title           string    `json:"title"`
+	Status          string    `json:"status"`
+	PipelineStatus  string    `json:"pipeline_status"`
+	MergeStatus     string    `json:"merge_status"`
+	ApprovedBy      []string  `json:"approved_by"`
+	Threads         int       `json:"threads"`
+	ResolvedThreads int       `json:"resolved_threads"`
+	ObservedAt      time.Time `json:"observed_at"`
+}
+
+func (s *Snapshot) sameState(other *Snapshot) bool {
+	if other == nil || len(s.ApprovedBy) != len(other.ApprovedBy) {
+		return false
+	}
+	for i := range s.ApprovedBy {
+		if s.ApprovedBy[i] != other.ApprovedBy[i] {
+			return false
+		}
+	}
+	return s.Student == other.Student &&
+		s.Task == other.Task &&
+		s.Title == other.Title &&
+		s.Status == other.Status &&
+		s.PipelineStatus == other.PipelineStatus &&
+		s.MergeStatus == other.MergeStatus &&
+		s.Threads == other.Threads &&
+		s.ResolvedThreads == other.ResolvedThreads
+}
+
+// Transition is a change of the review status of a merge request. From is
+// empty for merge requests seen for the first time.
+type Transition struct {
+	Url     string    `json:"url"`
+	Student string    `json:"student"`
+	Task    string    `json:"task"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	At      time.Time `json:"at"`
+}
+
+// Store is an embedded database of merge request snapshots and status
+// transitions. A snapshot is stored only when some observed field differs
+// from the previous one, so idle iterations do not grow the database.
+type Store struct {
+	db *bolt.DB
+}
+
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{latestBucket, snapshotsBucket, transitionsBucket, timelineBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Record stores snapshots observed in one iteration and returns status
+// transitions relative to the previously recorded state.
+func (s *Store) Record(snapshots []*Snapshot) ([]*Transition, error) {
+	transitions := make([]*Transition, 0)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		latest := tx.Bucket(latestBucket)
+		for _, snapshot := range snapshots {
+			previous, err := decodeSnapshot(latest.Get([]byte(snapshot.Url)))
+			if err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(snapshot)
+			if err != nil {
+				return err
+			}
+			if err := latest.Put([]byte(snapshot.Url), data); err != nil {
+				return err
+			}
+
+			if snapshot.sameState(previous) {
+				continue
+			}
+			if err := appendTo(tx, snapshotsBucket, snapshot.Url, snapshot.ObservedAt, data); err != nil {
+				return err
+			}
+
+			transition := newTransition(previous, snapshot)
+			if transition == nil {
+				continue
+			}
+			if err := putTransition(tx, transition); err != nil {
+				return err
+			}
+			transitions = append(transitions, transition)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transitions, nil
+}
+
+// Pending returns status transitions that recording the snapshots would
+// produce without storing anything.
+func (s *Store) Pending(snapshots []*Snapshot) ([]*Transition, error) {
+	transitions := make([]*Transition, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		latest := tx.Bucket(latestBucket)
+		for _, snapshot := range snapshots {
+			previous, err := decodeSnapshot(latest.Get([]byte(snapshot.Url)))
+			if err != nil {
+				return err
+			}
+			if transition := newTransition(previous, snapshot); transition != nil {
+				transitions = append(transitions, transition)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transitions, nil
+}
+
+// newTransition returns the status change from previous to snapshot or nil
+// if the status is the same.
+func newTransition(previous *Snapshot, snapshot *Snapshot) *Transition {
+	if previous != nil && previous.Status == snapshot.Status {
+		return nil
+	}
+	transition := &Transition{
+		Url:     snapshot.Url,
+		Student: snapshot.Student,
+		Task:    snapshot.Task,
+		To:      snapshot.Status,
+		At:      snapshot.ObservedAt,
+	}
+	if previous != nil {
+		transition.From = previous.Status
+	}
+	return transition
+}
+
+func putTransition(tx *bolt.Tx, transition *Transition) error {
+	data, err := json.Marshal(transition)
+	if err != nil {
+		return err
+	}
+	if err := appendTo(tx, transitionsBucket, transition.Url, transition.At, data); err != nil {
+		return err
+	}
+
+	timeline := tx.Bucket(timelineBucket)
+	seq, err := timeline.NextSequence()
+	if err != nil {
+		return err
+	}
+	return timeline.Put(timeKey(transition.At, seq), data)
+}
+
+// appendTo stores value in the per-url sub-bucket of the named bucket,
+// keyed by time so that cursors iterate in chronological order.
+func appendTo(tx *bolt.Tx, name []byte, url string, at time.Time, value []byte) error {
+	bucket, err := tx.Bucket(name).CreateBucketIfNotExists([]byte(url))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	return bucket.Put(timeKey(at, seq), value)
+}
+
+func timeKey(at time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(at.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// Known returns the number of merge requests ever recorded.
+func (s *Store) Known() (int, error) {
+	known := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		known = tx.Bucket(latestBucket).Stats().KeyN
+		return nil
+	})
+	return known, err
+}
+
+// Latest returns the most recently observed snapshot of the merge request
+// or nil if it has never been seen.
+func (s *Store) Latest(url string) (*Snapshot, error) {
+	var snapshot *Snapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		snapshot, err = decodeSnapshot(tx.Bucket(latestBucket).Get([]byte(url)))
+		return err
+	})
+	return snapshot, err
+}
+
+// Snapshots returns distinct states of the merge request, oldest first.
+func (s *Store) Snapshots(url string) ([]*Snapshot, error) {
+	res := make([]*Snapshot, 0)
+	err := s.forEach(snapshotsBucket, url, func(data []byte) error {
+		snapshot, err := decodeSnapshot(data)
+		if err != nil {
+			return err
+		}
+		res = append(res, snapshot)
+		return nil
+	})
+	return res, err
+}
+
+// Transitions returns status changes of the merge request, oldest first.
+func (s *Store) Transitions(url string) ([]*Transition, error) {
+	res := make([]*Transition, 0)
+	err := s.forEach(transitionsBucket, url, func(data []byte) error {
+		var transition Transition
+		if err := json.Unmarshal(data, &transition); err != nil {
+			return err
+		}
+		res = append(res, &transition)
+		return nil
+	})
+	return res, err
+}
+
+// TransitionsSince returns status changes of all merge requests recorded
+// at or after since, oldest first.
+func (s *Store) TransitionsSince(since time.Time) ([]*Transition, error) {
+	res := make([]*Transition, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(timelineBucket).Cursor()
+		for key, data := cursor.Seek(timeKey(since, 0)); key != nil; key, data = cursor.Next() {
+			var transition Transition
+			if err := json.Unmarshal(data, &transition); err != nil {
+				return err
+			}
+			res = append(res, &transition)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// FirstReached returns when the merge request first entered the status.
+func (s *Store) FirstReached(url string, status string) (time.Time, bool, error) {
+	transitions, err := s.Transitions(url)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for _, transition := range transitions {
+		if transition.To == status {
+			return transition.At, true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// CountReached returns how many times the merge request entered the status,
+// e.g. the number of resubmissions after a rejection.
+func (s *Store) CountReached(url string, status string) (int, error) {
+	transitions, err := s.Transitions(url)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, transition := range transitions {
+		if transition.To == status {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) forEach(name []byte, url string, cb func([]byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(name).Bucket([]byte(url))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key []byte, value []byte) error {
+			return cb(value)
+		})
+	})
+}
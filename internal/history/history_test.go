@@ -0,0 +1,175 @@
+package history
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+type change struct {
+	From string
+	To   string
+}
+
+func changes(transitions []*Transition) []change {
+	res := make([]change, 0, len(transitions))
+	for _, transition := range transitions {
+		res = append(res, change{From: transition.From, To: transition.To})
+	}
+	return res
+}
+
+func TestRecord(t *testing.T) {
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	snapshot := func(status string, threads int) *Snapshot {
+		return &Snapshot{Url: "https://gitlab/mr/1", Student: "alice", Task: "mutex", Status: status, Threads: threads, ObservedAt: at}
+	}
+
+	tests := []struct {
+		name       string
+		iterations []*Snapshot
+		want       [][]change
+		snapshots  int
+	}{
+		{
+			name:       "new merge request",
+			iterations: []*Snapshot{snapshot("pending", 0)},
+			want:       [][]change{{{From: "", To: "pending"}}},
+			snapshots:  1,
+		},
+		{
+			name:       "same state",
+			iterations: []*Snapshot{snapshot("pending", 0), snapshot("pending", 0)},
+			want:       [][]change{{{From: "", To: "pending"}}, {}},
+			snapshots:  1,
+		},
+		{
+			name:       "same status, new thread",
+			iterations: []*Snapshot{snapshot("pending", 0), snapshot("pending", 1)},
+			want:       [][]change{{{From: "", To: "pending"}}, {}},
+			snapshots:  2,
+		},
+		{
+			name:       "status change",
+			iterations: []*Snapshot{snapshot("pending", 0), snapshot("rejected", 1)},
+			want:       [][]change{{{From: "", To: "pending"}}, {{From: "pending", To: "rejected"}}},
+			snapshots:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := openStore(t)
+			for i, snapshot := range tt.iterations {
+				snapshot.ObservedAt = at.Add(time.Duration(i) * time.Minute)
+				transitions, err := store.Record([]*Snapshot{snapshot})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := changes(transitions); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("iteration %d: got %v, want %v", i, got, tt.want[i])
+				}
+			}
+
+			snapshots, err := store.Snapshots("https://gitlab/mr/1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(snapshots) != tt.snapshots {
+				t.Errorf("got %d snapshots, want %d", len(snapshots), tt.snapshots)
+			}
+		})
+	}
+}
+
+func TestPendingDoesNotStore(t *testing.T) {
+	store := openStore(t)
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	pending := &Snapshot{Url: "https://gitlab/mr/1", Status: "pending", ObservedAt: at}
+	approved := &Snapshot{Url: "https://gitlab/mr/1", Status: "approved", ObservedAt: at.Add(time.Hour)}
+
+	if _, err := store.Record([]*Snapshot{pending}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		transitions, err := store.Pending([]*Snapshot{approved})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []change{{From: "pending", To: "approved"}}
+		if got := changes(transitions); !reflect.DeepEqual(got, want) {
+			t.Errorf("attempt %d: got %v, want %v", i, got, want)
+		}
+	}
+
+	latest, err := store.Latest("https://gitlab/mr/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Status != "pending" {
+		t.Errorf("latest status is %q after Pending, want pending", latest.Status)
+	}
+
+	count, err := store.CountReached("https://gitlab/mr/1", "approved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("Pending stored %d transitions", count)
+	}
+}
+
+func TestFirstReached(t *testing.T) {
+	store := openStore(t)
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	statuses := []string{"pending", "rejected", "pending", "approved"}
+	for i, status := range statuses {
+		snapshot := &Snapshot{Url: "https://gitlab/mr/1", Status: status, ObservedAt: at.Add(time.Duration(i) * time.Hour)}
+		if _, err := store.Record([]*Snapshot{snapshot}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		status string
+		at     time.Time
+		found  bool
+		count  int
+	}{
+		{status: "pending", at: at, found: true, count: 2},
+		{status: "rejected", at: at.Add(time.Hour), found: true, count: 1},
+		{status: "approved", at: at.Add(3 * time.Hour), found: true, count: 1},
+		{status: "merged", found: false, count: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			reached, found, err := store.FirstReached("https://gitlab/mr/1", tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != tt.found || !reached.Equal(tt.at) {
+				t.Errorf("FirstReached = %v, %v, want %v, %v", reached, found, tt.at, tt.found)
+			}
+			count, err := store.CountReached("https://gitlab/mr/1", tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.count {
+				t.Errorf("CountReached = %d, want %d", count, tt.count)
+			}
+		})
+	}
+}
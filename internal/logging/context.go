@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+// WithLogger attaches the entry to ctx, so that everything logged on behalf
+// of ctx carries its fields, e.g. the course and the iteration id.
+func WithLogger(ctx context.Context, entry *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// FromContext returns the entry attached with WithLogger or the standard
+// logger.
+func FromContext(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value(loggerKey{}).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// Source returns the context logger tagged with the subsystem that logs,
+// such as gitlab, sheets or notify.
+func Source(ctx context.Context, source string) *log.Entry {
+	return FromContext(ctx).WithField("source", source)
+}
+
+// NewIterationId returns a random id correlating log lines of one sync
+// iteration.
+func NewIterationId() string {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
@@ -2,6 +2,8 @@ package logging
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path"
 	"runtime"
 	"time"
@@ -9,13 +11,31 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func InitLogging(level string) error {
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+type Options struct {
+	Level  string
+	Format string
+	// File is an optional path logs are written to in addition to stderr.
+	// It is rotated once it grows above MaxSizeMB, keeping MaxBackups
+	// previous files.
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+// InitLogging configures the standard logger. The returned closer closes
+// the log file, if any.
+func InitLogging(opts Options) (io.Closer, error) {
 	logLevel := log.InfoLevel
-	if level != "" {
+	if opts.Level != "" {
 		var err error
-		logLevel, err = log.ParseLevel(level)
+		logLevel, err = log.ParseLevel(opts.Level)
 		if err != nil {
-			return fmt.Errorf("failed to parse log level: %w", err)
+			return nil, fmt.Errorf("failed to parse log level: %w", err)
 		}
 	}
 
@@ -24,12 +44,39 @@ func InitLogging(level string) error {
 		return "", fmt.Sprintf("%s:%d", filename, f.Line)
 	}
 
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp:    true,
-		CallerPrettyfier: callerPrettyfier,
-		TimestampFormat:  time.RFC3339,
-	})
+	switch opts.Format {
+	case "", FormatText:
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp:    true,
+			CallerPrettyfier: callerPrettyfier,
+			TimestampFormat:  time.RFC3339,
+		})
+	case FormatJson:
+		log.SetFormatter(&log.JSONFormatter{
+			CallerPrettyfier: callerPrettyfier,
+			TimestampFormat:  time.RFC3339Nano,
+		})
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %s or %s", opts.Format, FormatText, FormatJson)
+	}
+
+	var closer io.Closer = nopCloser{}
+	if opts.File != "" {
+		file, err := openRotatingFile(opts.File, int64(opts.MaxSizeMB)<<20, opts.MaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		log.SetOutput(io.MultiWriter(os.Stderr, file))
+		closer = file
+	}
+
 	log.SetReportCaller(true)
 	log.SetLevel(logLevel)
+	return closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
 	return nil
 }
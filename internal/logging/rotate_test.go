@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBackups int
+		// blockRename makes renaming the file to path.1 fail.
+		blockRename bool
+		wantErr     bool
+		wantFiles   map[string]string
+	}{
+		{
+			name:       "rotates",
+			maxBackups: 2,
+			wantFiles: map[string]string{
+				"app.log":   "third\n",
+				"app.log.1": "second\n",
+				"app.log.2": "first\n",
+			},
+		},
+		{
+			name:        "keeps writing when rename fails",
+			maxBackups:  1,
+			blockRename: true,
+			wantErr:     true,
+			wantFiles: map[string]string{
+				"app.log": "first\nsecond\nthird\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "app.log")
+			if tt.blockRename {
+				if err := os.MkdirAll(filepath.Join(path+".1", "busy"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			f, err := openRotatingFile(path, 10, tt.maxBackups)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var writeErr error
+			for _, line := range []string{"first\n", "second\n", "third\n"} {
+				n, err := f.Write([]byte(line))
+				if n != len(line) {
+					t.Fatalf("wrote %d bytes of %q: %v", n, line, err)
+				}
+				if err != nil {
+					writeErr = err
+				}
+			}
+			if (writeErr != nil) != tt.wantErr {
+				t.Errorf("write error %v, want error: %t", writeErr, tt.wantErr)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, want := range tt.wantFiles {
+				data, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("%s contains %q, want %q", name, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileDiscardsAfterClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f, err := openRotatingFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Write([]byte("late\n")); n != 5 || err != nil {
+		t.Fatalf("Write after Close = %d, %v", n, err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "late") {
+		t.Errorf("line written after Close reached the file")
+	}
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "concurrency_watcher"
+
+const (
+	ServiceGitLab = "gitlab"
+	ServiceSheets = "sheets"
+)
+
+var (
+	IterationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "iteration_duration_seconds",
+		Help:      "Duration of sync iterations.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"course"})
+
+	Iterations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "iterations_total",
+		Help:      "Number of finished sync iterations by result.",
+	}, []string{"course", "result"})
+
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of requests to external services by result.",
+	}, []string{"course", "service", "operation", "result"})
+
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests to external services.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"course", "service", "operation"})
+
+	MergeRequests = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "merge_requests",
+		Help:      "Number of merge requests by review status as of the last iteration.",
+	}, []string{"course", "status"})
+
+	LastSheetCommit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_sheet_commit_timestamp_seconds",
+		Help:      "Unix time of the last successful sheet commit, including ones with nothing to change.",
+	}, []string{"course", "sheet"})
+)
+
+type courseKey struct{}
+
+// WithCourse attaches the course name to requests made with ctx.
+func WithCourse(ctx context.Context, course string) context.Context {
+	return context.WithValue(ctx, courseKey{}, course)
+}
+
+// CourseFromContext returns the course attached with WithCourse.
+func CourseFromContext(ctx context.Context) string {
+	course, _ := ctx.Value(courseKey{}).(string)
+	return course
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// ObserveRequest records a finished request to an external service.
+func ObserveRequest(ctx context.Context, service string, operation string, start time.Time, err error) {
+	course := CourseFromContext(ctx)
+	Requests.WithLabelValues(course, service, operation, resultLabel(err)).Inc()
+	RequestDuration.WithLabelValues(course, service, operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveIteration records a finished sync iteration.
+func ObserveIteration(course string, start time.Time, err error) {
+	Iterations.WithLabelValues(course, resultLabel(err)).Inc()
+	IterationDuration.WithLabelValues(course).Observe(time.Since(start).Seconds())
+}
+
+// SheetCommitted records a successful commit of the sheet. A write that
+// found the sheet up to date is a successful commit as well.
+func SheetCommitted(ctx context.Context, sheet string) {
+	LastSheetCommit.WithLabelValues(CourseFromContext(ctx), sheet).SetToCurrentTime()
+}
+
+var (
+	statusesMu sync.Mutex
+	statuses   = make(map[string]map[string]bool)
+)
+
+// SetMergeRequests replaces the per-status merge request counts of the
+// course. Statuses missing from counts are removed.
+func SetMergeRequests(course string, counts map[string]int) {
+	statusesMu.Lock()
+	defer statusesMu.Unlock()
+
+	for status := range statuses[course] {
+		if _, found := counts[status]; !found {
+			MergeRequests.DeleteLabelValues(course, status)
+		}
+	}
+
+	statuses[course] = make(map[string]bool, len(counts))
+	for status, count := range counts {
+		statuses[course][status] = true
+		MergeRequests.WithLabelValues(course, status).Set(float64(count))
+	}
+}
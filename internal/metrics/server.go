@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// Server exposes liveness, readiness and Prometheus metrics over HTTP.
+// Readiness is reported once SetReady is called, after every course has
+// finished its first successful iteration. An empty address disables the
+// server, Start and Shutdown then do nothing.
+type Server struct {
+	server *http.Server
+	ready  int32
+}
+
+func NewServer(addr string) *Server {
+	s := &Server{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.IsReady() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+
+	s.server = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	return s
+}
+
+func (s *Server) Start() {
+	if s.server.Addr == "" {
+		log.Infoln("Metrics server is disabled")
+		return
+	}
+	go func() {
+		log.Infof("Serving metrics on %s", s.server.Addr)
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Errorln("Metrics server failed")
+		}
+	}()
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.server.Addr == "" {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+func (s *Server) SetReady() {
+	atomic.StoreInt32(&s.ready, 1)
+}
+
+func (s *Server) IsReady() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
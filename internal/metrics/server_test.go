@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServerDisabled(t *testing.T) {
+	s := NewServer("")
+	s.Start()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown of a disabled server failed: %v", err)
+	}
+}
+
+func TestServerReadiness(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	s := NewServer(addr)
+	s.Start()
+	defer s.Shutdown(context.Background())
+
+	tests := []struct {
+		name  string
+		ready bool
+		path  string
+		want  int
+	}{
+		{name: "healthy", path: "/healthz", want: http.StatusOK},
+		{name: "not ready", path: "/readyz", want: http.StatusServiceUnavailable},
+		{name: "ready", ready: true, path: "/readyz", want: http.StatusOK},
+		{name: "metrics", path: "/metrics", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.ready {
+				s.SetReady()
+			}
+			var res *http.Response
+			var err error
+			for i := 0; i < 50; i++ {
+				res, err = http.Get("http://" + addr + tt.path)
+				if err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("%s returned %d, want %d", tt.path, res.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+)
+
+// ErrNoContact is returned by notifiers that cannot reach the recipient,
+// e.g. the Telegram notifier for someone without a chat id.
+var ErrNoContact = errors.New("Recipient has no contact for this notifier")
+
+type Recipient struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Email    string `json:"email,omitempty"`
+	Telegram string `json:"telegram,omitempty"`
+}
+
+type Message struct {
+	Recipient Recipient `json:"recipient"`
+	Subject   string    `json:"subject"`
+	Text      string    `json:"text"`
+}
+
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, msg *Message) error
+}
+
+// Dispatcher delivers every message through all configured notifiers.
+// Failures are logged and do not stop delivery through other notifiers.
+type Dispatcher struct {
+	notifiers []Notifier
+}
+
+func NewDispatcher(notifiers ...Notifier) *Dispatcher {
+	return &Dispatcher{
+		notifiers: notifiers,
+	}
+}
+
+func (d *Dispatcher) Send(ctx context.Context, msg *Message) {
+	for _, notifier := range d.notifiers {
+		err := notifier.Notify(ctx, msg)
+		if errors.Is(err, ErrNoContact) {
+			continue
+		}
+
+		entry := logging.Source(ctx, "notify").WithFields(log.Fields{
+			"notifier":  notifier.Name(),
+			"recipient": msg.Recipient.Username,
+		})
+		if err != nil {
+			entry.WithError(err).Warnln("Failed to send notification")
+		} else {
+			entry.Debugln("Sent notification")
+		}
+	}
+}
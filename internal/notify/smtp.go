@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Smtp sends plain text emails. Authentication is used only if a username
+// is configured, so an unauthenticated local relay works too.
+type Smtp struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+func NewSmtp(addr string, from string, username string, password string) *Smtp {
+	return &Smtp{
+		addr:     addr,
+		from:     from,
+		username: username,
+		password: password,
+	}
+}
+
+func (s *Smtp) Name() string {
+	return "smtp"
+}
+
+func (s *Smtp) Notify(ctx context.Context, msg *Message) error {
+	if msg.Recipient.Email == "" {
+		return ErrNoContact
+	}
+
+	var auth smtp.Auth
+	if s.username != "" {
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+
+	body := formatEmail(s.from, msg, time.Now())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.addr, auth, s.from, []string{msg.Recipient.Email}, body)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("Failed to send email: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// formatEmail builds the message. The subject contains student controlled
+// text, so line breaks are removed from it and it is Q-encoded, which
+// keeps it from adding headers.
+func formatEmail(from string, msg *Message, now time.Time) []byte {
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject)
+	header := []string{
+		"From: " + from,
+		"To: " + msg.Recipient.Email,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + now.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	return []byte(strings.Join(header, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatEmailSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string
+	}{
+		{name: "plain", subject: "sum: approved", want: "Subject: sum: approved"},
+		{name: "newline", subject: "a\nBcc: x@example.com", want: "Subject: a Bcc: x@example.com"},
+		{name: "carriage return", subject: "a\rBcc: x@example.com", want: "Subject: a Bcc: x@example.com"},
+		{name: "non ascii", subject: "задача", want: "Subject: =?utf-8?q?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := string(formatEmail("bot@example.com", &Message{
+				Recipient: Recipient{Email: "student@example.com"},
+				Subject:   tt.subject,
+				Text:      "text",
+			}, time.Unix(0, 0)))
+
+			headers := strings.Split(strings.SplitN(email, "\r\n\r\n", 2)[0], "\r\n")
+			if len(headers) != 6 {
+				t.Fatalf("got %d headers, want 6: %q", len(headers), headers)
+			}
+			if !strings.HasPrefix(headers[2], tt.want) {
+				t.Errorf("subject header = %q, want prefix %q", headers[2], tt.want)
+			}
+			for _, header := range headers {
+				if strings.ContainsAny(header, "\r\n") {
+					t.Errorf("header %q contains a line break", header)
+				}
+			}
+		})
+	}
+}
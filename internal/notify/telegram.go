@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultTelegramUrl = "https://api.telegram.org"
+
+const redactedToken = "<redacted>"
+
+// Telegram sends messages through the Telegram Bot API. The base url is
+// configurable so that a local stand-in can be used for testing.
+type Telegram struct {
+	baseUrl string
+	token   string
+	client  *http.Client
+}
+
+func NewTelegram(baseUrl string, token string) *Telegram {
+	if baseUrl == "" {
+		baseUrl = DefaultTelegramUrl
+	}
+
+	return &Telegram{
+		baseUrl: strings.TrimRight(baseUrl, "/"),
+		token:   token,
+		client:  http.DefaultClient,
+	}
+}
+
+func (t *Telegram) Name() string {
+	return "telegram"
+}
+
+func (t *Telegram) Notify(ctx context.Context, msg *Message) error {
+	if msg.Recipient.Telegram == "" {
+		return ErrNoContact
+	}
+
+	text := msg.Text
+	if msg.Subject != "" {
+		text = msg.Subject + "\n\n" + text
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  msg.Recipient.Telegram,
+		"text":                     text,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = postJson(ctx, t.client, fmt.Sprintf("%s/bot%s/sendMessage", t.baseUrl, t.token), body)
+	return t.redact(err)
+}
+
+// redact removes the token from errors, which contain the request url on
+// transport failures and would otherwise leak it into the logs.
+func (t *Telegram) redact(err error) error {
+	if err == nil || t.token == "" {
+		return err
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, t.token, redactedToken)
+	}
+	if strings.Contains(err.Error(), t.token) {
+		return errors.New(strings.ReplaceAll(err.Error(), t.token, redactedToken))
+	}
+	return err
+}
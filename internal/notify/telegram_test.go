@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testToken = "123456:secret-token"
+
+func TestTelegramErrorsDoNotLeakToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	baseUrl := server.URL
+	server.Close()
+
+	tests := []struct {
+		name    string
+		baseUrl string
+	}{
+		{name: "transport error", baseUrl: baseUrl},
+		{name: "invalid url", baseUrl: "http://[::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			telegram := NewTelegram(tt.baseUrl, testToken)
+			err := telegram.Notify(context.Background(), &Message{
+				Recipient: Recipient{Telegram: "42"},
+				Text:      "hello",
+			})
+			if err == nil {
+				t.Fatal("Notify() succeeded, want an error")
+			}
+			if strings.Contains(err.Error(), testToken) {
+				t.Errorf("Notify() error %q contains the token", err)
+			}
+		})
+	}
+}
+
+func TestTelegramSendsMessage(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer server.Close()
+
+	err := NewTelegram(server.URL, testToken).Notify(context.Background(), &Message{
+		Recipient: Recipient{Telegram: "42"},
+		Text:      "hello",
+	})
+	if err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	if want := "/bot" + testToken + "/sendMessage"; path != want {
+		t.Errorf("path = %q, want %q", path, want)
+	}
+}
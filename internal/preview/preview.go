@@ -0,0 +1,285 @@
+package preview
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/sheets"
+)
+
+// Renderer implements sheets.Writer by rendering tables to local files
+// instead of the spreadsheet. Every sheet becomes a CSV file, and Close
+// writes an index.html with all sheets and their cell colors. If a reader
+// for the live spreadsheet is provided, the difference between the current
+// and the planned contents is printed as well.
+type Renderer struct {
+	dir     string
+	current sheets.Reader
+	out     io.Writer
+	sheets  []*renderedSheet
+}
+
+type renderedSheet struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+func NewRenderer(dir string, current sheets.Reader, out io.Writer) (*Renderer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Renderer{
+		dir:     dir,
+		current: current,
+		out:     out,
+		sheets:  make([]*renderedSheet, 0),
+	}, nil
+}
+
+func (r *Renderer) WriteTable(ctx context.Context, sheet string, table *sheets.Table) error {
+	rows, err := table.SortedRows()
+	if err != nil {
+		return err
+	}
+	rendered := &renderedSheet{
+		Name:    sheet,
+		Columns: table.Columns,
+		Rows:    rows,
+	}
+	r.sheets = append(r.sheets, rendered)
+
+	path := filepath.Join(r.dir, fileName(sheet)+".csv")
+	if err := writeCsv(path, rendered); err != nil {
+		return err
+	}
+	logging.Source(ctx, "preview").Infof("Rendered %s to %s", sheet, path)
+
+	if r.current == nil {
+		return nil
+	}
+
+	current, err := r.current.ReadTable(ctx, sheet)
+	if err != nil {
+		logging.Source(ctx, "preview").WithError(err).Warnf("Failed to read %s from the spreadsheet, skipping diff", sheet)
+		return nil
+	}
+	printDiff(r.out, sheet, textRows(current.Columns, current.Rows), textRows(rendered.Columns, rendered.Rows))
+	return nil
+}
+
+// AppendTable renders only the rows that would be appended, there is
+// nothing to diff them against.
+func (r *Renderer) AppendTable(ctx context.Context, sheet string, table *sheets.Table) error {
+	rendered := &renderedSheet{
+		Name:    sheet + " (appended)",
+		Columns: table.Columns,
+		Rows:    table.Rows,
+	}
+	r.sheets = append(r.sheets, rendered)
+
+	path := filepath.Join(r.dir, fileName(sheet)+"_appended.csv")
+	if err := writeCsv(path, rendered); err != nil {
+		return err
+	}
+	logging.Source(ctx, "preview").Infof("Rendered %d rows appended to %s to %s", len(table.Rows), sheet, path)
+	return nil
+}
+
+func (r *Renderer) Close() error {
+	path := filepath.Join(r.dir, "index.html")
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := pageTemplate.Execute(f, r.sheets); err != nil {
+		return err
+	}
+	log.Infof("Rendered preview to %s", path)
+	return nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func fileName(sheet string) string {
+	return strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(sheet), "_"), "_")
+}
+
+func writeCsv(path string, sheet *renderedSheet) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if err := w.Write(sheet.Columns); err != nil {
+		return err
+	}
+	for _, row := range sheet.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = escapeCsv(sheets.DisplayText(value))
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// escapeCsv keeps spreadsheet applications from evaluating student
+// controlled text as a formula when the CSV is opened.
+func escapeCsv(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func textRows(columns []string, rows [][]interface{}) []string {
+	res := make([]string, 0, len(rows)+1)
+	res = append(res, strings.Join(columns, " | "))
+	for _, row := range rows {
+		texts := make([]string, len(row))
+		for i, value := range row {
+			texts[i] = sheets.DisplayText(value)
+		}
+		for len(texts) > 0 && texts[len(texts)-1] == "" {
+			texts = texts[:len(texts)-1]
+		}
+		res = append(res, strings.Join(texts, " | "))
+	}
+	return res
+}
+
+// printDiff prints removed and added rows using the longest common
+// subsequence of both row lists.
+func printDiff(out io.Writer, sheet string, before []string, after []string) {
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := make([]string, 0)
+	added, removed := 0, 0
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			i++
+			j++
+		case j < len(after) && (i == len(before) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, "+ "+after[j])
+			added++
+			j++
+		default:
+			lines = append(lines, "- "+before[i])
+			removed++
+			i++
+		}
+	}
+
+	if len(lines) == 0 {
+		fmt.Fprintf(out, "%s: no changes\n", sheet)
+		return
+	}
+	fmt.Fprintf(out, "--- %s (spreadsheet)\n+++ %s (dry run)\n", sheet, sheet)
+	for _, line := range lines {
+		fmt.Fprintln(out, line)
+	}
+	fmt.Fprintf(out, "%s: %d rows added, %d rows removed\n", sheet, added, removed)
+}
+
+func cssColor(property string, c *sheets.Color) string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s: rgb(%d, %d, %d); ", property, int(c.Red*0xff+0.5), int(c.Green*0xff+0.5), int(c.Blue*0xff+0.5))
+}
+
+func cellStyle(c sheets.Cell) template.CSS {
+	style := cssColor("background-color", c.BackgroundColor) + cssColor("color", c.TextColor)
+	if c.Bold {
+		style += "font-weight: bold; "
+	}
+	if c.Italic {
+		style += "font-style: italic; "
+	}
+	if c.Strikethrough {
+		style += "text-decoration: line-through; "
+	}
+	if c.Align != "" {
+		style += "text-align: " + strings.ToLower(c.Align) + "; "
+	}
+	if c.Wrap {
+		style += "white-space: normal; "
+	}
+	return template.CSS(style)
+}
+
+func cell(value interface{}) map[string]interface{} {
+	res := map[string]interface{}{
+		"Text":  sheets.DisplayText(value),
+		"Link":  "",
+		"Note":  "",
+		"Style": template.CSS(""),
+	}
+	if c, ok := value.(sheets.Cell); ok {
+		res["Link"] = c.Hyperlink
+		res["Note"] = c.Note
+		res["Style"] = cellStyle(c)
+	}
+	return res
+}
+
+var pageTemplate = template.Must(template.New("preview").Funcs(template.FuncMap{
+	"cell": cell,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Dry run</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; white-space: nowrap; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+{{range .}}
+<h2>{{.Name}}</h2>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}{{with cell .}}<td style="{{.Style}}" title="{{.Note}}">{{if .Link}}<a href="{{.Link}}">{{.Text}}</a>{{else}}{{.Text}}{{end}}</td>{{end}}{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
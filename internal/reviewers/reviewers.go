@@ -0,0 +1,176 @@
+package reviewers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const dateLayout = "2006-01-02"
+
+type Period struct {
+	From  string `yaml:"from"`
+	Until string `yaml:"until"`
+
+	from  time.Time
+	until time.Time
+}
+
+type Reviewer struct {
+	Username  string   `yaml:"username"`
+	Pseudonym string   `yaml:"pseudonym"`
+	Active    Period   `yaml:"active"`
+	Tasks     []string `yaml:"tasks"`
+	MaxLoad   int      `yaml:"max_load"`
+	Email     string   `yaml:"email"`
+	Telegram  string   `yaml:"telegram"`
+}
+
+// IsActive reports whether the reviewer is on duty at the given moment.
+// Both ends of the active period are optional and inclusive.
+func (r *Reviewer) IsActive(at time.Time) bool {
+	if !r.Active.from.IsZero() && at.Before(r.Active.from) {
+		return false
+	}
+	if !r.Active.until.IsZero() && !at.Before(r.Active.until.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// Reviews reports whether the reviewer accepts merge requests for the task.
+// Reviewers without an explicit task list review everything.
+func (r *Reviewer) Reviews(task string) bool {
+	if len(r.Tasks) == 0 {
+		return true
+	}
+	for _, t := range r.Tasks {
+		if t == task {
+			return true
+		}
+	}
+	return false
+}
+
+type file struct {
+	Reviewers []*Reviewer `yaml:"reviewers"`
+}
+
+type List struct {
+	reviewers []*Reviewer
+	byName    map[string]*Reviewer
+}
+
+func Parse(data []byte) (*List, error) {
+	var f file
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("Failed to decode reviewers: %w", err)
+	}
+
+	list := &List{
+		reviewers: f.Reviewers,
+		byName:    make(map[string]*Reviewer),
+	}
+
+	problems := make([]string, 0)
+	for i, reviewer := range f.Reviewers {
+		if reviewer == nil {
+			problems = append(problems, fmt.Sprintf("reviewers[%d]: empty entry", i))
+			continue
+		}
+		for _, problem := range reviewer.validate() {
+			problems = append(problems, fmt.Sprintf("reviewers[%d] (%s): %s", i, reviewer.Username, problem))
+		}
+		if _, found := list.byName[reviewer.Username]; found {
+			problems = append(problems, fmt.Sprintf("reviewers[%d] (%s): duplicate username", i, reviewer.Username))
+		}
+		list.byName[reviewer.Username] = reviewer
+	}
+	if len(problems) > 0 {
+		return nil, errors.New("Invalid reviewers: " + strings.Join(problems, "; "))
+	}
+
+	sort.SliceStable(list.reviewers, func(i, j int) bool {
+		return list.reviewers[i].Username < list.reviewers[j].Username
+	})
+	return list, nil
+}
+
+func Load(path string) (*List, error) {
+	list, _, err := load(path)
+	return list, err
+}
+
+// load reads the file once and returns the list parsed from it together
+// with the raw contents.
+func load(path string) (*List, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read reviewers file: %w", err)
+	}
+
+	list, err := Parse(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return list, data, nil
+}
+
+func (r *Reviewer) validate() []string {
+	problems := make([]string, 0)
+	if r.Username == "" {
+		problems = append(problems, "username is required")
+	}
+	if r.Pseudonym == "" {
+		problems = append(problems, "pseudonym is required")
+	}
+	if r.MaxLoad < 0 {
+		problems = append(problems, "max_load must not be negative")
+	}
+
+	var err error
+	if r.Active.From != "" {
+		if r.Active.from, err = time.Parse(dateLayout, r.Active.From); err != nil {
+			problems = append(problems, fmt.Sprintf("active.from %q is not a YYYY-MM-DD date", r.Active.From))
+		}
+	}
+	if r.Active.Until != "" {
+		if r.Active.until, err = time.Parse(dateLayout, r.Active.Until); err != nil {
+			problems = append(problems, fmt.Sprintf("active.until %q is not a YYYY-MM-DD date", r.Active.Until))
+		}
+	}
+	if !r.Active.from.IsZero() && !r.Active.until.IsZero() && r.Active.until.Before(r.Active.from) {
+		problems = append(problems, "active.until is before active.from")
+	}
+
+	for i, task := range r.Tasks {
+		if strings.TrimSpace(task) == "" {
+			problems = append(problems, fmt.Sprintf("tasks[%d] is empty", i))
+		}
+	}
+	return problems
+}
+
+func (l *List) Lookup(username string) (*Reviewer, bool) {
+	reviewer, found := l.byName[username]
+	return reviewer, found
+}
+
+// All returns reviewers sorted by username.
+func (l *List) All() []*Reviewer {
+	return l.reviewers
+}
+
+func (l *List) Len() int {
+	return len(l.reviewers)
+}
+
+func sameContents(lhs []byte, rhs []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(lhs), bytes.TrimSpace(rhs))
+}
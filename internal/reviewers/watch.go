@@ -0,0 +1,127 @@
+package reviewers
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Source keeps the latest valid reviewers list loaded from a file and
+// reloads it whenever the file changes. Invalid edits are logged and
+// ignored, so the previous list stays in effect.
+type Source struct {
+	path    string
+	watcher *fsnotify.Watcher
+
+	mu      sync.RWMutex
+	list    *List
+	content []byte
+}
+
+func NewSource(path string) (*Source, error) {
+	list, content, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Source{
+		path:    path,
+		list:    list,
+		content: content,
+	}, nil
+}
+
+func (s *Source) Current() *List {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list
+}
+
+// configMapData is the symlink Kubernetes swaps to update a mounted
+// ConfigMap. Files of the ConfigMap are symlinks through it, so they get
+// no events of their own.
+const configMapData = "..data"
+
+// Watch starts reloading the file on change. The directory is watched
+// instead of the file itself so that editors replacing the file by rename
+// and Kubernetes ConfigMap symlink swaps are noticed too.
+func (s *Source) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return err
+	}
+	s.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if s.affectedBy(event) {
+					s.Reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Warnln("Reviewers file watcher failed")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// affectedBy tells if the event may have changed the contents of the
+// file. Reload ignores events that did not.
+func (s *Source) affectedBy(event fsnotify.Event) bool {
+	if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	return name == filepath.Clean(s.path) || name == filepath.Join(filepath.Dir(s.path), configMapData)
+}
+
+func (s *Source) Reload() {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		log.WithError(err).Warnln("Failed to read reviewers file, keeping previous reviewers")
+		return
+	}
+
+	s.mu.RLock()
+	unchanged := sameContents(content, s.content)
+	s.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	list, err := Parse(content)
+	if err != nil {
+		log.WithError(err).Errorln("Failed to reload reviewers, keeping previous reviewers")
+		return
+	}
+
+	s.mu.Lock()
+	s.list = list
+	s.content = content
+	s.mu.Unlock()
+	log.Infof("Reloaded %d reviewers from %s", list.Len(), s.path)
+}
+
+func (s *Source) Close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	return s.watcher.Close()
+}
@@ -0,0 +1,90 @@
+package reviewers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestAffectedBy(t *testing.T) {
+	s := &Source{path: "/etc/watcher/reviewers.yml"}
+
+	tests := []struct {
+		name  string
+		event fsnotify.Event
+		want  bool
+	}{
+		{name: "write", event: fsnotify.Event{Name: "/etc/watcher/reviewers.yml", Op: fsnotify.Write}, want: true},
+		{name: "replaced by rename", event: fsnotify.Event{Name: "/etc/watcher/reviewers.yml", Op: fsnotify.Create}, want: true},
+		{name: "chmod", event: fsnotify.Event{Name: "/etc/watcher/reviewers.yml", Op: fsnotify.Chmod}, want: false},
+		{name: "other file", event: fsnotify.Event{Name: "/etc/watcher/config.yml", Op: fsnotify.Write}, want: false},
+		{name: "configmap swap", event: fsnotify.Event{Name: "/etc/watcher/..data", Op: fsnotify.Create}, want: true},
+		{name: "configmap temporary link", event: fsnotify.Event{Name: "/etc/watcher/..data_tmp", Op: fsnotify.Create}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.affectedBy(tt.event); got != tt.want {
+				t.Errorf("affectedBy(%v) = %t, want %t", tt.event, got, tt.want)
+			}
+		})
+	}
+}
+
+func writeReviewers(t *testing.T, path string, username string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := "reviewers:\n  - username: " + username + "\n    pseudonym: " + username + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWatchConfigMap swaps the ..data symlink the way the kubelet updates
+// a mounted ConfigMap.
+func TestWatchConfigMap(t *testing.T) {
+	dir := t.TempDir()
+	writeReviewers(t, filepath.Join(dir, "..2021_03_01", "reviewers.yml"), "alice")
+	if err := os.Symlink("..2021_03_01", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "reviewers.yml")
+	if err := os.Symlink(filepath.Join("..data", "reviewers.yml"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	if _, found := source.Current().Lookup("alice"); !found {
+		t.Fatal("alice is not loaded")
+	}
+	if err := source.Watch(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeReviewers(t, filepath.Join(dir, "..2021_03_02", "reviewers.yml"), "bob")
+	if err := os.Symlink("..2021_03_02", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, found := source.Current().Lookup("bob"); found {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("reviewers were not reloaded after the ConfigMap update")
+}
@@ -0,0 +1,58 @@
+package roster
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Student holds contacts of a student keyed by the GitLab username, which
+// is the merge request author.
+type Student struct {
+	Name     string `yaml:"name"`
+	GitLab   string `yaml:"gitlab"`
+	Email    string `yaml:"email"`
+	Telegram string `yaml:"telegram"`
+}
+
+type Roster struct {
+	byUsername map[string]*Student
+}
+
+func Empty() *Roster {
+	return &Roster{
+		byUsername: make(map[string]*Student),
+	}
+}
+
+func Load(path string) (*Roster, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read roster: %w", err)
+	}
+
+	var file struct {
+		Students []*Student `yaml:"students"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: Failed to decode roster: %w", path, err)
+	}
+
+	roster := Empty()
+	for i, student := range file.Students {
+		if student == nil || student.GitLab == "" {
+			return nil, fmt.Errorf("%s: students[%d]: gitlab username is required", path, i)
+		}
+		if _, found := roster.byUsername[student.GitLab]; found {
+			return nil, fmt.Errorf("%s: students[%d]: duplicate gitlab username %s", path, i, student.GitLab)
+		}
+		roster.byUsername[student.GitLab] = student
+	}
+	return roster, nil
+}
+
+func (r *Roster) Lookup(username string) (*Student, bool) {
+	student, found := r.byUsername[username]
+	return student, found
+}
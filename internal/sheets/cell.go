@@ -0,0 +1,267 @@
+package sheets
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// Horizontal alignments of a Cell.
+const (
+	AlignLeft   = "LEFT"
+	AlignCenter = "CENTER"
+	AlignRight  = "RIGHT"
+)
+
+// DefaultDateFormat is used for time.Time values without a Format.
+const DefaultDateFormat = "yyyy-mm-dd hh:mm:ss"
+
+// Cell is a value together with its formatting. Plain values in table
+// rows are written the same way as a Cell with only Value set.
+type Cell struct {
+	Text string
+	// Value is written instead of Text if set: ints and floats become
+	// numbers, time.Time a date, bool a boolean and Formula a formula.
+	Value interface{}
+	// Format is a number format pattern for Value, e.g. "0.00" or
+	// "yyyy-mm-dd".
+	Format    string
+	Hyperlink string
+	Note      string
+
+	BackgroundColor *Color
+	TextColor       *Color
+	Bold            bool
+	Italic          bool
+	Strikethrough   bool
+	// Align is one of AlignLeft, AlignCenter and AlignRight.
+	Align string
+	Wrap  bool
+}
+
+func formatCellData(value interface{}) *sheets.CellData {
+	cell := &sheets.CellData{
+		UserEnteredValue:  &sheets.ExtendedValue{},
+		UserEnteredFormat: &sheets.CellFormat{},
+	}
+
+	if value == nil {
+		return cell
+	}
+
+	c, ok := value.(Cell)
+	if !ok {
+		setValue(cell, value, "")
+		return cell
+	}
+
+	if c.Hyperlink != "" {
+		cell.UserEnteredValue.FormulaValue = hyperlink(c).String()
+	} else if c.Value != nil {
+		setValue(cell, c.Value, c.Format)
+	} else {
+		cell.UserEnteredValue.StringValue = c.Text
+	}
+
+	format := cell.UserEnteredFormat
+	format.BackgroundColor = c.BackgroundColor
+	if c.Bold || c.Italic || c.Strikethrough || c.TextColor != nil {
+		format.TextFormat = &sheets.TextFormat{
+			Bold:            c.Bold,
+			Italic:          c.Italic,
+			Strikethrough:   c.Strikethrough,
+			ForegroundColor: c.TextColor,
+		}
+	}
+	format.HorizontalAlignment = c.Align
+	if c.Wrap {
+		format.WrapStrategy = "WRAP"
+	}
+	cell.Note = c.Note
+
+	return cell
+}
+
+// setValue stores a typed value. Zero numbers and false are omitted from
+// requests unless forced.
+func setValue(cell *sheets.CellData, value interface{}, pattern string) {
+	v := cell.UserEnteredValue
+	numberType := "NUMBER"
+	switch x := value.(type) {
+	case string:
+		// Sheets stores stringValue literally, it is never parsed as a
+		// formula.
+		v.StringValue = x
+	case Formula:
+		v.FormulaValue = x.String()
+	case bool:
+		v.BoolValue = x
+		v.ForceSendFields = []string{"BoolValue"}
+	case time.Time:
+		v.NumberValue = serialDate(x)
+		v.ForceSendFields = []string{"NumberValue"}
+		numberType = "DATE_TIME"
+		if pattern == "" {
+			pattern = DefaultDateFormat
+		}
+	default:
+		number, ok := toNumber(value)
+		if !ok {
+			v.StringValue = fmt.Sprint(value)
+			break
+		}
+		v.NumberValue = number
+		v.ForceSendFields = []string{"NumberValue"}
+	}
+
+	if pattern != "" {
+		cell.UserEnteredFormat.NumberFormat = &sheets.NumberFormat{
+			Type:    numberType,
+			Pattern: pattern,
+		}
+	}
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch x := value.(type) {
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	default:
+		return 0, false
+	}
+}
+
+// compareValues orders values the way a spreadsheet sorts them:
+// numbers and dates, then text, then booleans, then empty cells.
+func compareValues(lhs interface{}, rhs interface{}) int {
+	lhsRank, lhsNumber, lhsText := sortKey(lhs)
+	rhsRank, rhsNumber, rhsText := sortKey(rhs)
+	switch {
+	case lhsRank != rhsRank:
+		return lhsRank - rhsRank
+	case lhsNumber < rhsNumber:
+		return -1
+	case lhsNumber > rhsNumber:
+		return 1
+	default:
+		return strings.Compare(lhsText, rhsText)
+	}
+}
+
+func sortKey(value interface{}) (int, float64, string) {
+	if c, ok := value.(Cell); ok && c.Value != nil && c.Text == "" && c.Hyperlink == "" {
+		value = c.Value
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return 0, serialDate(v), ""
+	case bool:
+		if v {
+			return 2, 1, ""
+		}
+		return 2, 0, ""
+	}
+	if number, ok := toNumber(value); ok {
+		return 0, number, ""
+	}
+	if text := DisplayText(value); text != "" {
+		return 1, 0, text
+	}
+	return 3, 0, ""
+}
+
+// serialEpoch is day zero of spreadsheet dates.
+var serialEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// serialDate converts the wall clock time of t to days since serialEpoch,
+// which is how spreadsheets store dates.
+func serialDate(t time.Time) float64 {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return wall.Sub(serialEpoch).Hours() / 24
+}
+
+// DisplayText returns the text a value is shown as in the spreadsheet.
+func DisplayText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case Cell:
+		if v.Text == "" && v.Hyperlink == "" && v.Value != nil {
+			return formatValue(v.Value, v.Format)
+		}
+		if v.Text == "" {
+			return v.Hyperlink
+		}
+		return v.Text
+	default:
+		return formatValue(v, "")
+	}
+}
+
+func formatValue(value interface{}, pattern string) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if pattern == "" {
+			pattern = DefaultDateFormat
+		}
+		return v.Format(dateLayout(pattern))
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// dateLayout converts the common parts of a date format pattern to a Go
+// time layout. "mm" means minutes after hours and months otherwise.
+func dateLayout(pattern string) string {
+	var layout strings.Builder
+	afterHours := false
+	for len(pattern) > 0 {
+		switch {
+		case strings.HasPrefix(pattern, "yyyy"):
+			layout.WriteString("2006")
+			pattern = pattern[4:]
+		case strings.HasPrefix(pattern, "mm"):
+			if afterHours {
+				layout.WriteString("04")
+			} else {
+				layout.WriteString("01")
+			}
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "dd"):
+			layout.WriteString("02")
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "hh"):
+			layout.WriteString("15")
+			afterHours = true
+			pattern = pattern[2:]
+		case strings.HasPrefix(pattern, "ss"):
+			layout.WriteString("05")
+			pattern = pattern[2:]
+		default:
+			layout.WriteByte(pattern[0])
+			pattern = pattern[1:]
+		}
+	}
+	return layout.String()
+}
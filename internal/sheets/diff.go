@@ -0,0 +1,458 @@
+package sheets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+)
+
+// cellFields are the parts of a cell generated tables manage, as a field
+// mask of UpdateCells. cellReadFields selects the same parts when reading,
+// plus the formatted value used to tell zeros from empty cells.
+const (
+	cellFields     = "userEnteredValue,userEnteredFormat.backgroundColor,userEnteredFormat.numberFormat,userEnteredFormat.textFormat,userEnteredFormat.horizontalAlignment,userEnteredFormat.wrapStrategy,note"
+	cellReadFields = "userEnteredValue,userEnteredFormat(backgroundColor,numberFormat,textFormat,horizontalAlignment,wrapStrategy),note,formattedValue"
+)
+
+// colorTolerance absorbs float32 rounding of colors stored by Sheets.
+const colorTolerance = 1.0 / 512
+
+// grid is the current contents of a sheet.
+type grid struct {
+	sheetId     int64
+	rowCount    int64
+	columnCount int64
+	rows        [][]*sheets.CellData
+}
+
+// readGrids reads the sheets in a single request. Missing sheets are only
+// looked for, and created if the client may, once the read fails because
+// of them, so reading existing sheets costs one request.
+func (c *Client) readGrids(ctx context.Context, table string, sheetNames ...string) (map[string]*grid, error) {
+	grids, err := c.getGrids(ctx, table, sheetNames...)
+	if err == nil || c.options == nil || !isUnknownRange(err) {
+		return grids, err
+	}
+
+	if _, err := c.ensureSheets(ctx, table, sheetNames...); err != nil {
+		return nil, err
+	}
+	return c.getGrids(ctx, table, sheetNames...)
+}
+
+// isUnknownRange tells if the request failed because a range refers to a
+// sheet that does not exist.
+func isUnknownRange(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) &&
+		apiErr.Code == http.StatusBadRequest &&
+		strings.Contains(apiErr.Message, "Unable to parse range")
+}
+
+func (c *Client) getGrids(ctx context.Context, table string, sheetNames ...string) (map[string]*grid, error) {
+	start := time.Now()
+	res, err := c.service.Spreadsheets.Get(table).
+		Ranges(sheetNames...).
+		IncludeGridData(true).
+		Fields("sheets(properties(sheetId,title,gridProperties(rowCount,columnCount)),data(rowData(values(" + cellReadFields + "))))").
+		Context(ctx).
+		Do()
+	metrics.ObserveRequest(ctx, metrics.ServiceSheets, "get", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	grids := make(map[string]*grid)
+	for _, sheetRef := range res.Sheets {
+		g := &grid{
+			sheetId: sheetRef.Properties.SheetId,
+		}
+		if props := sheetRef.Properties.GridProperties; props != nil {
+			g.rowCount = props.RowCount
+			g.columnCount = props.ColumnCount
+		}
+		for _, data := range sheetRef.Data {
+			for _, row := range data.RowData {
+				g.rows = append(g.rows, row.Values)
+			}
+		}
+		grids[sheetRef.Properties.Title] = g
+	}
+
+	for _, sheet := range sheetNames {
+		if _, found := grids[sheet]; !found {
+			return nil, fmt.Errorf("Unknown sheet %s", sheet)
+		}
+	}
+	return grids, nil
+}
+
+func (g *grid) cell(row int, column int) *sheets.CellData {
+	if row >= len(g.rows) || column >= len(g.rows[row]) {
+		return nil
+	}
+	return g.rows[row][column]
+}
+
+// apply returns a copy of the grid with the requests generated for it
+// applied: appended dimensions, updated and appended cells and deleted
+// rows. Formulas written by the requests have no formatted value yet, so
+// they compare as empty.
+func (g *grid) apply(requests []*sheets.Request) *grid {
+	res := &grid{
+		sheetId:     g.sheetId,
+		rowCount:    g.rowCount,
+		columnCount: g.columnCount,
+		rows:        make([][]*sheets.CellData, len(g.rows)),
+	}
+	for i, row := range g.rows {
+		res.rows[i] = append([]*sheets.CellData(nil), row...)
+	}
+
+	for _, request := range requests {
+		switch {
+		case request.AppendDimension != nil:
+			if request.AppendDimension.Dimension == "ROWS" {
+				res.rowCount += request.AppendDimension.Length
+			} else {
+				res.columnCount += request.AppendDimension.Length
+			}
+		case request.UpdateCells != nil:
+			start := request.UpdateCells.Start
+			for i, row := range request.UpdateCells.Rows {
+				for j, cell := range row.Values {
+					res.set(int(start.RowIndex)+i, int(start.ColumnIndex)+j, cell)
+				}
+			}
+		case request.AppendCells != nil:
+			for _, row := range request.AppendCells.Rows {
+				res.rows = append(res.rows, append([]*sheets.CellData(nil), row.Values...))
+			}
+		case request.DeleteDimension != nil && request.DeleteDimension.Range.Dimension == "ROWS":
+			start, end := int(request.DeleteDimension.Range.StartIndex), int(request.DeleteDimension.Range.EndIndex)
+			if start >= len(res.rows) {
+				continue
+			}
+			if end > len(res.rows) {
+				end = len(res.rows)
+			}
+			res.rows = append(res.rows[:start], res.rows[end:]...)
+		}
+	}
+	return res
+}
+
+func (g *grid) set(row int, column int, cell *sheets.CellData) {
+	for len(g.rows) <= row {
+		g.rows = append(g.rows, nil)
+	}
+	for len(g.rows[row]) <= column {
+		g.rows[row] = append(g.rows[row], nil)
+	}
+	g.rows[row][column] = cell
+}
+
+// WriteDiff makes the sheet show the table by updating only cells whose
+// value, background or note differ, in a single batchUpdate. Rows are
+// sorted locally. If nothing changed, the only request is the read, unless
+// the sheet is missing and has to be created first.
+// Keyed tables are upserted instead, see Table.KeyBy.
+func (c *Client) WriteDiff(ctx context.Context, table string, sheet string, t *Table) error {
+	return c.WriteDiffs(ctx, table, []NamedTable{{Sheet: sheet, Table: t}})
+}
+
+// WriteDiffs is WriteDiff for several sheets. Changes of all sheets are
+// sent in one batchUpdate, so either every sheet is updated or none is.
+func (c *Client) WriteDiffs(ctx context.Context, table string, tables []NamedTable) error {
+	sheetNames := make([]string, len(tables))
+	for i, named := range tables {
+		sheetNames[i] = named.Sheet
+	}
+	grids, err := c.readGrids(ctx, table, sheetNames...)
+	if err != nil {
+		return err
+	}
+
+	requests := make([]*sheets.Request, 0)
+	changed := make([]string, 0, len(tables))
+	for _, named := range tables {
+		sheetRequests, err := tableRequests(grids[named.Sheet], named.Table)
+		if err != nil {
+			return fmt.Errorf("Failed to update %s: %w", named.Sheet, err)
+		}
+		if len(sheetRequests) == 0 {
+			logging.Source(ctx, "sheets").Debugf("%s is up to date", named.Sheet)
+			continue
+		}
+		requests = append(requests, sheetRequests...)
+		changed = append(changed, named.Sheet)
+	}
+	if len(requests) > 0 {
+		if err := c.batch(ctx, table, requests...); err != nil {
+			logging.Source(ctx, "sheets").WithError(err).Errorf("Failed to update %s", strings.Join(changed, ", "))
+			return err
+		}
+	}
+	// Sheets that are already up to date count as committed too, otherwise
+	// the commit timestamp would go stale while nothing changes.
+	for _, named := range tables {
+		metrics.SheetCommitted(ctx, named.Sheet)
+	}
+	return nil
+}
+
+// tableRequests returns requests making the sheet show the table: an
+// upsert followed by a sort for keyed tables and a diff otherwise.
+func tableRequests(current *grid, t *Table) ([]*sheets.Request, error) {
+	if len(t.Key) == 0 {
+		desired, err := desiredRows(t)
+		if err != nil {
+			return nil, err
+		}
+		return diffRequests(current, desired), nil
+	}
+
+	requests, header, err := t.upsert().requests(current)
+	if err != nil || len(t.SortBy) == 0 {
+		return requests, err
+	}
+	// Custom orders are sorted locally, on the grid as the upsert leaves
+	// it, the same way SortQuery does.
+	if hasCustomOrder(t.SortBy) {
+		sortRequests, err := sortRequests(current.apply(requests), t.SortBy)
+		if err != nil {
+			return nil, err
+		}
+		return append(requests, sortRequests...), nil
+	}
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	sortRequest, err := sortRangeRequest(current.sheetId, t.SortBy, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(requests, sortRequest), nil
+}
+
+func desiredRows(t *Table) ([][]*sheets.CellData, error) {
+	rows, err := t.SortedRows()
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make([][]*sheets.CellData, 0, len(rows)+1)
+	header := make([]*sheets.CellData, len(t.Columns))
+	for i, column := range t.Columns {
+		header[i] = formatCellData(column)
+	}
+	desired = append(desired, header)
+	for _, row := range rows {
+		cells := make([]*sheets.CellData, len(row))
+		for i, value := range row {
+			cells[i] = formatCellData(value)
+		}
+		desired = append(desired, cells)
+	}
+	return desired, nil
+}
+
+// diffRequests returns requests turning current into desired: grid
+// expansion if needed, then an UpdateCells per run of adjacent changed
+// cells in a row. Cells outside desired are cleared.
+func diffRequests(current *grid, desired [][]*sheets.CellData) []*sheets.Request {
+	numRows, numColumns := len(current.rows), 0
+	if len(desired) > numRows {
+		numRows = len(desired)
+	}
+	for _, rows := range [][][]*sheets.CellData{current.rows, desired} {
+		for _, row := range rows {
+			if len(row) > numColumns {
+				numColumns = len(row)
+			}
+		}
+	}
+
+	requests := make([]*sheets.Request, 0)
+	if extra := int64(len(desired)) - current.rowCount; extra > 0 {
+		requests = append(requests, appendDimension(current.sheetId, "ROWS", extra))
+	}
+	if extra := int64(numColumns) - current.columnCount; extra > 0 {
+		requests = append(requests, appendDimension(current.sheetId, "COLUMNS", extra))
+	}
+
+	desiredGrid := &grid{rows: desired}
+	for row := 0; row < numRows; row++ {
+		var run []*sheets.CellData
+		runStart := 0
+		flush := func() {
+			if len(run) == 0 {
+				return
+			}
+			requests = append(requests, &sheets.Request{
+				UpdateCells: &sheets.UpdateCellsRequest{
+					Fields: cellFields,
+					Start: &sheets.GridCoordinate{
+						SheetId:     current.sheetId,
+						RowIndex:    int64(row),
+						ColumnIndex: int64(runStart),
+					},
+					Rows: []*sheets.RowData{{Values: run}},
+				},
+			})
+			run = nil
+		}
+
+		for column := 0; column < numColumns; column++ {
+			want := desiredGrid.cell(row, column)
+			if sameCell(current.cell(row, column), want) {
+				flush()
+				continue
+			}
+			if sameCell(want, nil) {
+				// An empty value is not accepted, omitting the fields
+				// clears them.
+				want = &sheets.CellData{}
+			}
+			if len(run) == 0 {
+				runStart = column
+			}
+			run = append(run, want)
+		}
+		flush()
+	}
+
+	return requests
+}
+
+func appendDimension(sheetId int64, dimension string, length int64) *sheets.Request {
+	return &sheets.Request{
+		AppendDimension: &sheets.AppendDimensionRequest{
+			SheetId:   sheetId,
+			Dimension: dimension,
+			Length:    length,
+		},
+	}
+}
+
+func sameCell(lhs *sheets.CellData, rhs *sheets.CellData) bool {
+	if lhs == nil {
+		lhs = &sheets.CellData{}
+	}
+	if rhs == nil {
+		rhs = &sheets.CellData{}
+	}
+	return lhs.Note == rhs.Note &&
+		sameValue(lhs, rhs) &&
+		sameColor(background(lhs), background(rhs)) &&
+		sameFormat(format(lhs), format(rhs))
+}
+
+func sameValue(lhs *sheets.CellData, rhs *sheets.CellData) bool {
+	lhsValue, rhsValue := lhs.UserEnteredValue, rhs.UserEnteredValue
+	if lhsValue == nil {
+		lhsValue = &sheets.ExtendedValue{}
+	}
+	if rhsValue == nil {
+		rhsValue = &sheets.ExtendedValue{}
+	}
+	return valueKind(lhsValue, lhs.FormattedValue) == valueKind(rhsValue, rhs.FormattedValue) &&
+		lhsValue.StringValue == rhsValue.StringValue &&
+		lhsValue.FormulaValue == rhsValue.FormulaValue &&
+		lhsValue.BoolValue == rhsValue.BoolValue &&
+		lhsValue.NumberValue == rhsValue.NumberValue
+}
+
+// valueKind tells what a value holds. Zero numbers and false are omitted
+// from JSON, so they are recognized by ForceSendFields on cells built by
+// formatCellData and by the formatted value on cells read from the sheet.
+func valueKind(value *sheets.ExtendedValue, formatted string) string {
+	switch {
+	case value.FormulaValue != "":
+		return "formula"
+	case value.StringValue != "":
+		return "string"
+	case value.BoolValue || forced(value.ForceSendFields, "BoolValue") || formatted == "FALSE":
+		return "bool"
+	case value.NumberValue != 0 || forced(value.ForceSendFields, "NumberValue") || formatted != "":
+		return "number"
+	default:
+		return ""
+	}
+}
+
+func forced(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func format(cell *sheets.CellData) *sheets.CellFormat {
+	if cell.UserEnteredFormat == nil {
+		return &sheets.CellFormat{}
+	}
+	return cell.UserEnteredFormat
+}
+
+func sameFormat(lhs *sheets.CellFormat, rhs *sheets.CellFormat) bool {
+	lhsNumber, rhsNumber := lhs.NumberFormat, rhs.NumberFormat
+	if lhsNumber == nil {
+		lhsNumber = &sheets.NumberFormat{}
+	}
+	if rhsNumber == nil {
+		rhsNumber = &sheets.NumberFormat{}
+	}
+	lhsText, rhsText := lhs.TextFormat, rhs.TextFormat
+	if lhsText == nil {
+		lhsText = &sheets.TextFormat{}
+	}
+	if rhsText == nil {
+		rhsText = &sheets.TextFormat{}
+	}
+	return lhsNumber.Pattern == rhsNumber.Pattern &&
+		(lhsNumber.Pattern == "" || lhsNumber.Type == rhsNumber.Type) &&
+		lhsText.Bold == rhsText.Bold &&
+		lhsText.Italic == rhsText.Italic &&
+		lhsText.Strikethrough == rhsText.Strikethrough &&
+		sameColor(lhsText.ForegroundColor, rhsText.ForegroundColor) &&
+		lhs.HorizontalAlignment == rhs.HorizontalAlignment &&
+		lhs.WrapStrategy == rhs.WrapStrategy
+}
+
+func background(cell *sheets.CellData) *Color {
+	if cell.UserEnteredFormat == nil {
+		return nil
+	}
+	return cell.UserEnteredFormat.BackgroundColor
+}
+
+func sameColor(lhs *Color, rhs *Color) bool {
+	if lhs == nil || rhs == nil {
+		return lhs == rhs
+	}
+	return math.Abs(lhs.Red-rhs.Red) < colorTolerance &&
+		math.Abs(lhs.Green-rhs.Green) < colorTolerance &&
+		math.Abs(lhs.Blue-rhs.Blue) < colorTolerance &&
+		math.Abs(alpha(lhs)-alpha(rhs)) < colorTolerance
+}
+
+// alpha treats a missing alpha as opaque, which is how Sheets omits it.
+func alpha(color *Color) float64 {
+	if color.Alpha == 0 {
+		return 1
+	}
+	return color.Alpha
+}
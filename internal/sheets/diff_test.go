@@ -0,0 +1,197 @@
+package sheets
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// describe renders requests compactly: UpdateCells as "update row:column+n"
+// and AppendDimension as "append DIMENSION n".
+func describe(requests []*sheets.Request) []string {
+	res := make([]string, 0, len(requests))
+	for _, request := range requests {
+		switch {
+		case request.UpdateCells != nil:
+			start := request.UpdateCells.Start
+			res = append(res, fmt.Sprintf("update %d:%d+%d", start.RowIndex, start.ColumnIndex, len(request.UpdateCells.Rows[0].Values)))
+		case request.AppendDimension != nil:
+			res = append(res, fmt.Sprintf("append %s %d", request.AppendDimension.Dimension, request.AppendDimension.Length))
+		default:
+			res = append(res, requestKind(request))
+		}
+	}
+	return res
+}
+
+func TestDiffRequests(t *testing.T) {
+	current := storedGrid(
+		[]interface{}{"Student", "Score", "Passed"},
+		[]interface{}{"alice", 0, false},
+		[]interface{}{"bob", 2, true},
+	)
+	current.rowCount = 3
+	current.columnCount = 3
+
+	tests := []struct {
+		name    string
+		columns []string
+		rows    [][]interface{}
+		want    []string
+	}{
+		{
+			name:    "unchanged zero and false",
+			columns: []string{"Student", "Score", "Passed"},
+			rows:    [][]interface{}{{"alice", 0, false}, {"bob", 2, true}},
+			want:    []string{},
+		},
+		{
+			name:    "zero becomes empty",
+			columns: []string{"Student", "Score", "Passed"},
+			rows:    [][]interface{}{{"alice", nil, false}, {"bob", 2, true}},
+			want:    []string{"update 1:1+1"},
+		},
+		{
+			name:    "adjacent changes are one run",
+			columns: []string{"Student", "Score", "Passed"},
+			rows:    [][]interface{}{{"alice", 1, true}, {"bob", 2, true}},
+			want:    []string{"update 1:1+2"},
+		},
+		{
+			name:    "number becomes text",
+			columns: []string{"Student", "Score", "Passed"},
+			rows:    [][]interface{}{{"alice", "0", false}, {"bob", 2, true}},
+			want:    []string{"update 1:1+1"},
+		},
+		{
+			name:    "removed row is cleared",
+			columns: []string{"Student", "Score", "Passed"},
+			rows:    [][]interface{}{{"alice", 0, false}},
+			want:    []string{"update 2:0+3"},
+		},
+		{
+			name:    "removed column is cleared",
+			columns: []string{"Student", "Passed"},
+			rows:    [][]interface{}{{"alice", false}, {"bob", true}},
+			want:    []string{"update 0:1+2", "update 1:1+2", "update 2:1+2"},
+		},
+		{
+			name:    "reordered columns are rewritten",
+			columns: []string{"Student", "Passed", "Score"},
+			rows:    [][]interface{}{{"alice", false, 0}, {"bob", true, 2}},
+			want:    []string{"update 0:1+2", "update 1:1+2", "update 2:1+2"},
+		},
+		{
+			name:    "grid grows",
+			columns: []string{"Student", "Score", "Passed", "Comment"},
+			rows:    [][]interface{}{{"alice", 0, false, ""}, {"bob", 2, true, ""}, {"carol", 1, true, "late"}},
+			want:    []string{"append ROWS 1", "append COLUMNS 1", "update 0:3+1", "update 3:0+4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := NewTable(tt.columns...)
+			for _, row := range tt.rows {
+				table.Append(row...)
+			}
+			desired, err := desiredRows(table)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := describe(diffRequests(current, desired))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValueKind(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     *sheets.ExtendedValue
+		formatted string
+		want      string
+	}{
+		{name: "empty", value: &sheets.ExtendedValue{}, want: ""},
+		{name: "text", value: &sheets.ExtendedValue{StringValue: "0"}, formatted: "0", want: "string"},
+		{name: "formula", value: &sheets.ExtendedValue{FormulaValue: "=1+1"}, formatted: "2", want: "formula"},
+		{name: "true", value: &sheets.ExtendedValue{BoolValue: true}, formatted: "TRUE", want: "bool"},
+		{name: "written false", value: &sheets.ExtendedValue{ForceSendFields: []string{"BoolValue"}}, want: "bool"},
+		{name: "read false", value: &sheets.ExtendedValue{}, formatted: "FALSE", want: "bool"},
+		{name: "number", value: &sheets.ExtendedValue{NumberValue: 1.5}, formatted: "1.5", want: "number"},
+		{name: "written zero", value: &sheets.ExtendedValue{ForceSendFields: []string{"NumberValue"}}, want: "number"},
+		{name: "read zero", value: &sheets.ExtendedValue{}, formatted: "0", want: "number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := valueKind(tt.value, tt.formatted); got != tt.want {
+				t.Errorf("valueKind = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameCell(t *testing.T) {
+	red := &Color{Red: 1}
+	nearlyRed := &Color{Red: 0.999, Alpha: 1}
+
+	tests := []struct {
+		name string
+		lhs  *sheets.CellData
+		rhs  *sheets.CellData
+		want bool
+	}{
+		{name: "both missing", lhs: nil, rhs: nil, want: true},
+		{name: "missing and empty", lhs: nil, rhs: formatCellData(nil), want: true},
+		{name: "missing and zero", lhs: nil, rhs: formatCellData(0), want: false},
+		{name: "stored zero", lhs: stored(0), rhs: formatCellData(0), want: true},
+		{name: "stored false", lhs: stored(false), rhs: formatCellData(false), want: true},
+		{name: "zero and false", lhs: stored(0), rhs: formatCellData(false), want: false},
+		{name: "different notes", lhs: stored(Cell{Text: "a", Note: "x"}), rhs: formatCellData(Cell{Text: "a"}), want: false},
+		{name: "color rounding", lhs: stored(Cell{Text: "a", BackgroundColor: nearlyRed}), rhs: formatCellData(Cell{Text: "a", BackgroundColor: red}), want: true},
+		{name: "different colors", lhs: stored(Cell{Text: "a"}), rhs: formatCellData(Cell{Text: "a", BackgroundColor: red}), want: false},
+		{name: "bold", lhs: stored(Cell{Text: "a"}), rhs: formatCellData(Cell{Text: "a", Bold: true}), want: false},
+		{name: "number format", lhs: stored(Cell{Value: 1, Format: "0.00"}), rhs: formatCellData(Cell{Value: 1}), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameCell(tt.lhs, tt.rhs); got != tt.want {
+				t.Errorf("sameCell = %t, want %t", got, tt.want)
+			}
+			if got := sameCell(tt.rhs, tt.lhs); got != tt.want {
+				t.Errorf("sameCell is not symmetric")
+			}
+		})
+	}
+}
+
+func TestIsUnknownRange(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "unknown sheet", err: &googleapi.Error{Code: 400, Message: "Unable to parse range: Reviews"}, want: true},
+		{name: "other bad request", err: &googleapi.Error{Code: 400, Message: "Invalid requests[0]"}, want: false},
+		{name: "not found", err: &googleapi.Error{Code: 404, Message: "Requested entity was not found."}, want: false},
+		{name: "wrapped", err: fmt.Errorf("read: %w", &googleapi.Error{Code: 400, Message: "Unable to parse range: Events"}), want: true},
+		{name: "other error", err: errors.New("Unable to parse range"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnknownRange(tt.err); got != tt.want {
+				t.Errorf("isUnknownRange = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
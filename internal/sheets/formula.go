@@ -0,0 +1,70 @@
+package sheets
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Formula is a formula built by Call. Use it as a table value or as
+// Cell.Value; plain strings are always written as literal text, even if
+// they start with "=", "+" or "-".
+type Formula struct {
+	expression string
+}
+
+var functionName = regexp.MustCompile(`^[A-Z][A-Z0-9_.]*$`)
+
+// argumentSeparator matches the formulas the spreadsheets already contain.
+const argumentSeparator = ";"
+
+// Call builds a call of the spreadsheet function. Strings become quoted
+// literals with embedded quotes doubled, so that no argument can end the
+// literal and inject formula code. Numbers and bools are written as is and
+// Formula arguments are nested. It panics on an invalid function name or
+// argument type, which are programming errors.
+func Call(function string, args ...interface{}) Formula {
+	if !functionName.MatchString(function) {
+		panic(fmt.Sprintf("invalid function name %q", function))
+	}
+
+	formatted := make([]string, len(args))
+	for i, arg := range args {
+		formatted[i] = formulaArgument(arg)
+	}
+	return Formula{expression: function + "(" + strings.Join(formatted, argumentSeparator) + ")"}
+}
+
+func (f Formula) String() string {
+	return "=" + f.expression
+}
+
+func formulaArgument(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return quoteString(v)
+	case Formula:
+		return v.expression
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	}
+	if number, ok := toNumber(arg); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	panic(fmt.Sprintf("unsupported formula argument %T", arg))
+}
+
+func quoteString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// hyperlink builds the HYPERLINK formula of the cell. Links in
+// textFormatRuns would avoid the formula, but the vendored Sheets API has
+// no TextFormat.Link yet.
+func hyperlink(c Cell) Formula {
+	if c.Text == "" {
+		return Call("HYPERLINK", c.Hyperlink)
+	}
+	return Call("HYPERLINK", c.Hyperlink, c.Text)
+}
@@ -0,0 +1,79 @@
+package sheets
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name     string
+		function string
+		args     []interface{}
+		want     string
+	}{
+		{name: "no arguments", function: "NOW", want: "=NOW()"},
+		{name: "string", function: "LEN", args: []interface{}{"abc"}, want: `=LEN("abc")`},
+		{name: "quotes are doubled", function: "LEN", args: []interface{}{`say "hi"`}, want: `=LEN("say ""hi""")`},
+		{name: "injection stays a literal", function: "LEN", args: []interface{}{`"); IMPORTXML("http://evil`}, want: `=LEN("""); IMPORTXML(""http://evil")`},
+		{name: "formula-like text", function: "LEN", args: []interface{}{"=1+1"}, want: `=LEN("=1+1")`},
+		{name: "numbers", function: "SUM", args: []interface{}{1, 2.5, int64(-3)}, want: "=SUM(1;2.5;-3)"},
+		{name: "bools", function: "AND", args: []interface{}{true, false}, want: "=AND(TRUE;FALSE)"},
+		{name: "nested", function: "IF", args: []interface{}{Call("ISBLANK", "x"), "", "y"}, want: `=IF(ISBLANK("x");"";"y")`},
+		{name: "dotted name", function: "T.DIST", args: []interface{}{1, 2, true}, want: "=T.DIST(1;2;TRUE)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Call(tt.function, tt.args...).String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallPanics(t *testing.T) {
+	tests := []struct {
+		name     string
+		function string
+		args     []interface{}
+	}{
+		{name: "lower case name", function: "len"},
+		{name: "name with call", function: "LEN(A1);NOW"},
+		{name: "empty name", function: ""},
+		{name: "unsupported argument", function: "LEN", args: []interface{}{time.Now()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Call(%q) did not panic", tt.function)
+				}
+			}()
+			Call(tt.function, tt.args...)
+		})
+	}
+}
+
+func TestStringsAreLiteral(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{name: "plain string", value: "=IMPORTXML(\"http://evil\")", want: ""},
+		{name: "cell text", value: Cell{Text: "+1"}, want: ""},
+		{name: "hyperlink", value: Cell{Text: `a "b"`, Hyperlink: "https://gitlab/mr/1"}, want: `=HYPERLINK("https://gitlab/mr/1";"a ""b""")`},
+		{name: "bare hyperlink", value: Cell{Hyperlink: "https://gitlab/mr/1"}, want: `=HYPERLINK("https://gitlab/mr/1")`},
+		{name: "formula", value: Call("NOW"), want: "=NOW()"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatCellData(tt.value).UserEnteredValue.FormulaValue; got != tt.want {
+				t.Errorf("formula is %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
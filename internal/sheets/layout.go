@@ -0,0 +1,137 @@
+package sheets
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+)
+
+// SheetOptions describe how a missing sheet is created.
+type SheetOptions struct {
+	// Index is the position of the new tab, clamped to the number of
+	// tabs. Negative values put it after the existing ones.
+	Index         int
+	TabColor      *Color
+	FreezeHeader  bool
+	ProtectHeader bool
+}
+
+// CreateMissing makes the client create sheets that do not exist instead
+// of failing with "Unknown sheet", so that an empty spreadsheet can be
+// used. options tells how to create each sheet; nil disables creation.
+func (c *Client) CreateMissing(options func(sheet string) SheetOptions) *Client {
+	c.options = options
+	return c
+}
+
+// ensureSheets returns ids of all tabs in the spreadsheet after creating
+// the missing ones among sheetNames in a single batchUpdate.
+func (c *Client) ensureSheets(ctx context.Context, table string, sheetNames ...string) (map[string]int64, error) {
+	sheetIds, err := c.sheetIds(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	type newSheet struct {
+		name    string
+		options SheetOptions
+	}
+	missing := make([]newSheet, 0)
+	for _, sheet := range sheetNames {
+		if _, found := sheetIds[sheet]; found {
+			continue
+		}
+		if c.options == nil {
+			return nil, fmt.Errorf("Unknown sheet %s", sheet)
+		}
+		sheetIds[sheet] = int64(rand.Int31())
+		missing = append(missing, newSheet{name: sheet, options: c.options(sheet)})
+	}
+	if len(missing) == 0 {
+		return sheetIds, nil
+	}
+
+	// Tabs with explicit positions are added first, in order, so that
+	// earlier ones do not shift later ones.
+	sort.SliceStable(missing, func(i, j int) bool {
+		lhs, rhs := missing[i].options.Index, missing[j].options.Index
+		return lhs >= 0 && (rhs < 0 || lhs < rhs)
+	})
+
+	numSheets := len(sheetIds) - len(missing)
+	requests := make([]*sheets.Request, 0, 2*len(missing))
+	for _, sheet := range missing {
+		logging.Source(ctx, "sheets").Infof("Creating missing sheet %s", sheet.name)
+		requests = append(requests, addSheetRequests(sheetIds[sheet.name], sheet.name, sheet.options, numSheets)...)
+		numSheets++
+	}
+
+	if err := c.batch(ctx, table, requests...); err != nil {
+		logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to create missing sheets")
+		return nil, err
+	}
+	return sheetIds, nil
+}
+
+func addSheetRequests(sheetId int64, title string, options SheetOptions, numSheets int) []*sheets.Request {
+	properties := &sheets.SheetProperties{
+		SheetId:  sheetId,
+		Title:    title,
+		TabColor: options.TabColor,
+	}
+	if options.Index >= 0 {
+		properties.Index = int64(options.Index)
+		if options.Index > numSheets {
+			properties.Index = int64(numSheets)
+		}
+		properties.ForceSendFields = []string{"Index"}
+	}
+	if options.FreezeHeader {
+		properties.GridProperties = &sheets.GridProperties{
+			FrozenRowCount: 1,
+		}
+	}
+
+	requests := []*sheets.Request{{
+		AddSheet: &sheets.AddSheetRequest{
+			Properties: properties,
+		},
+	}}
+	if options.ProtectHeader {
+		requests = append(requests, &sheets.Request{
+			AddProtectedRange: &sheets.AddProtectedRangeRequest{
+				ProtectedRange: &sheets.ProtectedRange{
+					Description: "Generated header",
+					Range: &sheets.GridRange{
+						SheetId:       sheetId,
+						StartRowIndex: 0,
+						EndRowIndex:   1,
+					},
+				},
+			},
+		})
+	}
+	return requests
+}
+
+// ParseColor parses a #rrggbb color.
+func ParseColor(s string) (*Color, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return nil, fmt.Errorf("Color %q is not in #rrggbb format", s)
+	}
+	rgb, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Color %q is not in #rrggbb format", s)
+	}
+	return &Color{
+		Red:   float64(rgb>>16&0xff) / 255,
+		Green: float64(rgb>>8&0xff) / 255,
+		Blue:  float64(rgb&0xff) / 255,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package sheets
+
+import (
+	"context"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+)
+
+// Rotate keeps at most maxRows data rows in the sheet. Older rows, which
+// are at the top of append-only sheets, are appended to the archive sheet
+// and removed from the original one in a single batchUpdate, so a failure
+// never leaves a row in both sheets. Cells are copied with their values and
+// formats, so timestamps stay dates.
+func (c *Client) Rotate(ctx context.Context, table string, sheet string, archive string, maxRows int) error {
+	grids, err := c.readGrids(ctx, table, sheet, archive)
+	if err != nil {
+		return err
+	}
+
+	requests := rotateRequests(grids[sheet], grids[archive], maxRows)
+	if len(requests) == 0 {
+		return nil
+	}
+
+	if err := c.batch(ctx, table, requests...); err != nil {
+		logging.Source(ctx, "sheets").WithError(err).Errorf("Failed to archive rows of %s", sheet)
+		return err
+	}
+
+	logging.Source(ctx, "sheets").Infof("Moved %d rows from %s to %s", len(grids[sheet].rows)-1-maxRows, sheet, archive)
+	return nil
+}
+
+// rotateRequests returns requests moving rows above maxRows from source to
+// the end of archive. Columns are matched by header, the ones missing in
+// the archive are added to it.
+func rotateRequests(source *grid, archive *grid, maxRows int) []*sheets.Request {
+	if len(source.rows) <= maxRows+1 {
+		return nil
+	}
+	overflow := len(source.rows) - 1 - maxRows
+
+	header := make([]string, 0)
+	if len(archive.rows) > 0 {
+		for _, cell := range archive.rows[0] {
+			header = append(header, DisplayText(cellValue(cell)))
+		}
+	}
+	for len(header) > 0 && header[len(header)-1] == "" {
+		header = header[:len(header)-1]
+	}
+
+	mapping := newMappingFromFields(header...)
+	columns := make([]int, len(source.rows[0]))
+	added := make([]int, 0)
+	for i, cell := range source.rows[0] {
+		field := DisplayText(cellValue(cell))
+		index, found := mapping.columnToIndex[field]
+		if !found {
+			index = mapping.add(field)
+			header = append(header, field)
+			added = append(added, index)
+		}
+		columns[i] = index
+	}
+
+	requests := make([]*sheets.Request, 0)
+	if archive.rowCount == 0 {
+		requests = append(requests, appendDimension(archive.sheetId, "ROWS", 1))
+	}
+	if extra := int64(len(header)) - archive.columnCount; extra > 0 {
+		requests = append(requests, appendDimension(archive.sheetId, "COLUMNS", extra))
+	}
+	for _, index := range added {
+		requests = append(requests, updateCell(archive.sheetId, 0, index, formatCellData(header[index])))
+	}
+
+	rows := make([]*sheets.RowData, overflow)
+	for i := range rows {
+		cells := make([]*sheets.CellData, len(header))
+		for j := range cells {
+			cells[j] = &sheets.CellData{}
+		}
+		for j, column := range columns {
+			if cell := source.cell(1+i, j); cell != nil {
+				cells[column] = &sheets.CellData{
+					UserEnteredValue:  cell.UserEnteredValue,
+					UserEnteredFormat: cell.UserEnteredFormat,
+					Note:              cell.Note,
+				}
+			}
+		}
+		rows[i] = &sheets.RowData{Values: cells}
+	}
+
+	return append(requests,
+		&sheets.Request{
+			AppendCells: &sheets.AppendCellsRequest{
+				Fields:  cellFields,
+				SheetId: archive.sheetId,
+				Rows:    rows,
+			},
+		},
+		&sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    source.sheetId,
+					Dimension:  "ROWS",
+					StartIndex: 1,
+					EndIndex:   int64(1 + overflow),
+				},
+			},
+		},
+	)
+}
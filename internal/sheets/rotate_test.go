@@ -0,0 +1,140 @@
+package sheets
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func gridOf(sheetId int64, rows ...[]interface{}) *grid {
+	g := &grid{sheetId: sheetId, rowCount: 1000, columnCount: 26}
+	for _, row := range rows {
+		cells := make([]*sheets.CellData, len(row))
+		for i, value := range row {
+			cells[i] = formatCellData(value)
+		}
+		g.rows = append(g.rows, cells)
+	}
+	return g
+}
+
+func TestRotateRequests(t *testing.T) {
+	at := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := gridOf(1,
+		[]interface{}{"Timestamp", "Url"},
+		[]interface{}{at, "a"},
+		[]interface{}{at.Add(time.Hour), "b"},
+		[]interface{}{at.Add(2 * time.Hour), "c"},
+	)
+
+	tests := []struct {
+		name    string
+		archive *grid
+		maxRows int
+		// kinds of the requests in order
+		want []string
+	}{
+		{
+			name:    "below limit",
+			archive: gridOf(2),
+			maxRows: 3,
+			want:    nil,
+		},
+		{
+			name:    "empty archive",
+			archive: gridOf(2),
+			maxRows: 1,
+			want:    []string{"updateCells", "updateCells", "appendCells", "deleteDimension"},
+		},
+		{
+			name:    "archive with header in other order",
+			archive: gridOf(2, []interface{}{"Url", "Timestamp"}),
+			maxRows: 1,
+			want:    []string{"appendCells", "deleteDimension"},
+		},
+		{
+			name:    "archive missing a column",
+			archive: gridOf(2, []interface{}{"Url"}),
+			maxRows: 2,
+			want:    []string{"updateCells", "appendCells", "deleteDimension"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := rotateRequests(events, tt.archive, tt.maxRows)
+			if len(requests) != len(tt.want) {
+				t.Fatalf("got %d requests, want %d", len(requests), len(tt.want))
+			}
+			for i, request := range requests {
+				if got := requestKind(request); got != tt.want[i] {
+					t.Errorf("request %d is %s, want %s", i, got, tt.want[i])
+				}
+			}
+			if len(requests) == 0 {
+				return
+			}
+
+			moved := len(events.rows) - 1 - tt.maxRows
+			remove := requests[len(requests)-1].DeleteDimension.Range
+			if remove.SheetId != 1 || remove.StartIndex != 1 || remove.EndIndex != int64(1+moved) {
+				t.Errorf("deletes rows %d-%d of sheet %d", remove.StartIndex, remove.EndIndex, remove.SheetId)
+			}
+
+			appended := requests[len(requests)-2].AppendCells
+			if appended.SheetId != 2 || len(appended.Rows) != moved {
+				t.Fatalf("appends %d rows to sheet %d", len(appended.Rows), appended.SheetId)
+			}
+			header := make(map[string]int)
+			for i, name := range archiveHeader(tt.archive, requests) {
+				header[name] = i
+			}
+			first := appended.Rows[0].Values
+			if !sameCell(first[header["Timestamp"]], events.rows[1][0]) || !sameCell(first[header["Url"]], events.rows[1][1]) {
+				t.Errorf("first archived row does not match the first event")
+			}
+			if first[header["Timestamp"]].UserEnteredValue.NumberValue == 0 {
+				t.Errorf("timestamp is not archived as a serial number")
+			}
+		})
+	}
+}
+
+// archiveHeader returns the archive header after the requests add columns.
+func archiveHeader(archive *grid, requests []*sheets.Request) []string {
+	header := make([]string, 0)
+	if len(archive.rows) > 0 {
+		for _, cell := range archive.rows[0] {
+			header = append(header, DisplayText(cellValue(cell)))
+		}
+	}
+	for _, request := range requests {
+		if request.UpdateCells == nil {
+			continue
+		}
+		index := int(request.UpdateCells.Start.ColumnIndex)
+		for len(header) <= index {
+			header = append(header, "")
+		}
+		header[index] = DisplayText(cellValue(request.UpdateCells.Rows[0].Values[0]))
+	}
+	return header
+}
+
+func requestKind(request *sheets.Request) string {
+	switch {
+	case request.UpdateCells != nil:
+		return "updateCells"
+	case request.AppendCells != nil:
+		return "appendCells"
+	case request.AppendDimension != nil:
+		return "appendDimension"
+	case request.DeleteDimension != nil:
+		return "deleteDimension"
+	case request.SortRange != nil:
+		return "sortRange"
+	default:
+		return "other"
+	}
+}
@@ -5,22 +5,21 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"time"
 
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
 )
 
 type Color = sheets.Color
 
-type Cell struct {
-	Text            string
-	Hyperlink       string
-	BackgroundColor *Color
-}
-
 type Client struct {
 	service *sheets.Service
+	// options tells how to create missing sheets, see CreateMissing.
+	options func(sheet string) SheetOptions
 }
 
 func NewClient(ctx context.Context, credentialsFile string) (*Client, error) {
@@ -37,23 +36,32 @@ func NewClient(ctx context.Context, credentialsFile string) (*Client, error) {
 type InsertQuery struct {
 	err error
 
+	ctx     context.Context
 	client  *Client
 	table   string
 	sheet   string
 	sheetId int64
 	fields  []string
 	values  [][]interface{}
+	sortBy  []SortKey
+}
+
+func (c *Client) Insert(ctx context.Context, table string, sheet string) *InsertQuery {
+	sheetId, err := c.findSheetId(ctx, table, sheet)
+	q := c.insert(ctx, table, sheet, sheetId)
+	q.err = err
+	return q
 }
 
-func (c *Client) Insert(table string, sheet string) *InsertQuery {
-	sheetId, err := c.findSheetId(table, sheet)
+// insert is Insert into a sheet whose id is already known.
+func (c *Client) insert(ctx context.Context, table string, sheet string, sheetId int64) *InsertQuery {
 	return &InsertQuery{
+		ctx:     ctx,
 		client:  c,
 		table:   table,
 		sheet:   sheet,
 		sheetId: sheetId,
 		values:  make([][]interface{}, 0),
-		err:     err,
 	}
 }
 
@@ -72,6 +80,12 @@ func (q *InsertQuery) Rows(rows [][]interface{}) *InsertQuery {
 	return q
 }
 
+// SortBy sorts the values locally before they are appended.
+func (q *InsertQuery) SortBy(keys ...SortKey) *InsertQuery {
+	q.sortBy = keys
+	return q
+}
+
 func (q *InsertQuery) Do() error {
 	if q.err != nil {
 		return q.err
@@ -81,6 +95,14 @@ func (q *InsertQuery) Do() error {
 		return nil
 	}
 
+	if len(q.sortBy) > 0 {
+		indices, err := columnIndices(q.sortBy, q.fields)
+		if err != nil {
+			return err
+		}
+		sortRows(q.values, q.sortBy, indices)
+	}
+
 	mapping, err := q.getSchema()
 	if err != nil {
 		return err
@@ -144,7 +166,7 @@ func (m *columnMapping) add(field string) int {
 }
 
 func (q *InsertQuery) getSchema() (*columnMapping, error) {
-	mapping, err := loadSchema(q.client, q.table, q.sheet)
+	mapping, err := loadSchema(q.ctx, q.client, q.table, q.sheet)
 	if err != nil {
 		return nil, err
 	}
@@ -168,12 +190,14 @@ func (q *InsertQuery) getSchema() (*columnMapping, error) {
 	return mapping, nil
 }
 
-func loadSchema(client *Client, table string, sheet string) (*columnMapping, error) {
+func loadSchema(ctx context.Context, client *Client, table string, sheet string) (*columnMapping, error) {
 	firstRowRange := sheet + "!1:1"
 
-	res, err := client.service.Spreadsheets.Values.Get(table, firstRowRange).Do()
+	start := time.Now()
+	res, err := client.service.Spreadsheets.Values.Get(table, firstRowRange).Context(ctx).Do()
+	metrics.ObserveRequest(ctx, metrics.ServiceSheets, "values.get", start, err)
 	if err != nil {
-		log.WithError(err).Errorln("Failed to get first table row")
+		logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to get first table row")
 		return nil, err
 	}
 
@@ -182,7 +206,7 @@ func loadSchema(client *Client, table string, sheet string) (*columnMapping, err
 	}
 
 	if len(res.Values) != 1 {
-		log.Errorf("Failed to get first table row")
+		logging.Source(ctx, "sheets").Errorf("Failed to get first table row")
 		return nil, errors.New(fmt.Sprintf("Expected row of length 1, not %d (%v)", len(res.Values), res.Values))
 	}
 
@@ -207,9 +231,11 @@ func (q *InsertQuery) putSchema(mapping *columnMapping) error {
 		valueRange.Values[0][index] = field
 	}
 
-	_, err := q.client.service.Spreadsheets.Values.Update(q.table, q.sheet, valueRange).ValueInputOption("RAW").Do()
+	start := time.Now()
+	_, err := q.client.service.Spreadsheets.Values.Update(q.table, q.sheet, valueRange).ValueInputOption("RAW").Context(q.ctx).Do()
+	metrics.ObserveRequest(q.ctx, metrics.ServiceSheets, "values.update", start, err)
 	if err != nil {
-		log.WithError(err).Errorln("Failed to put table schema")
+		logging.Source(q.ctx, "sheets").WithError(err).Errorln("Failed to put table schema")
 		return err
 	}
 
@@ -235,37 +261,6 @@ func (q *InsertQuery) validateSchema(mapping *columnMapping) (*columnMapping, er
 	return mapping, nil
 }
 
-func formatCellData(value interface{}) *sheets.CellData {
-	cell := &sheets.CellData{
-		UserEnteredValue:  &sheets.ExtendedValue{},
-		UserEnteredFormat: &sheets.CellFormat{},
-	}
-
-	if value == nil {
-		return cell
-	}
-
-	switch v := value.(type) {
-	case Cell:
-		if v.Hyperlink != "" {
-			if v.Text != "" {
-				cell.UserEnteredValue.FormulaValue = fmt.Sprintf("=HYPERLINK(\"%s\";\"%s\")", v.Hyperlink, v.Text)
-			} else {
-				cell.UserEnteredValue.FormulaValue = fmt.Sprintf("=HYPERLINK(\"%s\")", v.Hyperlink)
-			}
-		} else {
-			cell.UserEnteredValue.StringValue = v.Text
-		}
-		if v.BackgroundColor != nil {
-			cell.UserEnteredFormat.BackgroundColor = v.BackgroundColor
-		}
-	default:
-		cell.UserEnteredValue.StringValue = fmt.Sprintf("%s", value)
-	}
-
-	return cell
-}
-
 func (q *InsertQuery) execute(mapping *columnMapping) error {
 	if len(q.fields) == 0 {
 		return nil
@@ -289,7 +284,7 @@ func (q *InsertQuery) execute(mapping *columnMapping) error {
 		}
 	}
 
-	err := q.client.batch(q.table, &sheets.Request{
+	err := q.client.batch(q.ctx, q.table, &sheets.Request{
 		AppendCells: &sheets.AppendCellsRequest{
 			Fields:  "*",
 			SheetId: q.sheetId,
@@ -298,7 +293,7 @@ func (q *InsertQuery) execute(mapping *columnMapping) error {
 	})
 
 	if err != nil {
-		log.WithError(err).Errorln("Failed to append values")
+		logging.Source(q.ctx, "sheets").WithError(err).Errorln("Failed to append values")
 		return err
 	}
 
@@ -306,13 +301,15 @@ func (q *InsertQuery) execute(mapping *columnMapping) error {
 }
 
 type DeleteQuery struct {
+	ctx    context.Context
 	client *Client
 	table  string
 	sheet  string
 }
 
-func (c *Client) Delete(table string, sheet string) *DeleteQuery {
+func (c *Client) Delete(ctx context.Context, table string, sheet string) *DeleteQuery {
 	return &DeleteQuery{
+		ctx:    ctx,
 		client: c,
 		table:  table,
 		sheet:  sheet,
@@ -320,181 +317,254 @@ func (c *Client) Delete(table string, sheet string) *DeleteQuery {
 }
 
 func (q *DeleteQuery) Do() error {
-	_, err := q.client.service.Spreadsheets.Values.Clear(q.table, q.sheet, &sheets.ClearValuesRequest{}).Do()
+	start := time.Now()
+	_, err := q.client.service.Spreadsheets.Values.Clear(q.table, q.sheet, &sheets.ClearValuesRequest{}).Context(q.ctx).Do()
+	metrics.ObserveRequest(q.ctx, metrics.ServiceSheets, "values.clear", start, err)
 	return err
 }
 
 type SortQuery struct {
+	ctx     context.Context
 	client  *Client
 	table   string
 	sheet   string
 	sheetId *int64
-	columns []string
+	keys    []SortKey
 }
 
-func (c *Client) Sort(table string, sheet string) *SortQuery {
-	sheetId, err := c.findSheetId(table, sheet)
-	sheetIdRef := &sheetId
+func (c *Client) Sort(ctx context.Context, table string, sheet string) *SortQuery {
+	sheetId, err := c.findSheetId(ctx, table, sheet)
+	q := c.sort(ctx, table, sheet, sheetId)
 	if err != nil {
-		sheetIdRef = nil
+		q.sheetId = nil
 	}
+	return q
+}
 
+// sort is Sort of a sheet whose id is already known.
+func (c *Client) sort(ctx context.Context, table string, sheet string, sheetId int64) *SortQuery {
 	return &SortQuery{
+		ctx:     ctx,
 		client:  c,
 		table:   table,
 		sheet:   sheet,
-		sheetId: sheetIdRef,
+		sheetId: &sheetId,
 	}
 }
 
+// By sorts by the columns in ascending order.
 func (q *SortQuery) By(columns ...string) *SortQuery {
-	q.columns = columns
+	return q.Keys(ascending(columns)...)
+}
+
+func (q *SortQuery) Keys(keys ...SortKey) *SortQuery {
+	q.keys = keys
 	return q
 }
 
+// Do sorts rows below the header with a SortRange request. Custom orders
+// cannot be expressed there, so such sorts read the sheet and rewrite it
+// in the new order instead.
 func (q *SortQuery) Do() error {
 	if q.sheetId == nil {
 		return errors.New("Unknown sheet")
 	}
 
-	schema, err := loadSchema(q.client, q.table, q.sheet)
+	if hasCustomOrder(q.keys) {
+		return q.sortLocally()
+	}
+
+	schema, err := loadSchema(q.ctx, q.client, q.table, q.sheet)
 	if err != nil {
 		return err
 	}
-
-	specs := make([]*sheets.SortSpec, len(q.columns))
-	for i := range specs {
-		specs[i] = &sheets.SortSpec{
-			SortOrder:      "ASCENDING",
-			DimensionIndex: int64(schema.columnToIndex[q.columns[i]]),
-		}
+	if schema == nil {
+		return nil
 	}
 
-	requests := []*sheets.Request{{
-		SortRange: &sheets.SortRangeRequest{
-			Range: &sheets.GridRange{
-				SheetId:       *q.sheetId,
-				StartRowIndex: 1,
-			},
-			SortSpecs: specs,
-		},
-	}}
-
-	req := &sheets.BatchUpdateSpreadsheetRequest{
-		Requests: requests,
+	columns := make([]string, schema.numColumns)
+	for column, index := range schema.columnToIndex {
+		columns[index] = column
+	}
+	request, err := sortRangeRequest(*q.sheetId, q.keys, columns)
+	if err != nil {
+		return err
 	}
 
-	res, err := q.client.service.Spreadsheets.BatchUpdate(q.table, req).Do()
-	_ = res
+	return q.client.batch(q.ctx, q.table, request)
+}
 
+func (q *SortQuery) sortLocally() error {
+	grids, err := q.client.readGrids(q.ctx, q.table, q.sheet)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	requests, err := sortRequests(grids[q.sheet], q.keys)
+	if err != nil || len(requests) == 0 {
+		return err
+	}
+	return q.client.batch(q.ctx, q.table, requests...)
 }
 
+// Snapshot is a hidden copy of a sheet that queries write to until the
+// transaction it belongs to is committed or rolled back.
 type Snapshot struct {
+	ctx               context.Context
 	client            *Client
+	tx                *Transaction
 	table             string
 	originalSheetName string
 	originalSheetId   int64
 	tempSheetName     string
 	tempSheetId       int64
+	metadataId        int64
+	created           time.Time
 }
 
-func (c *Client) Snapshot(table string, sheet string) (*Snapshot, error) {
-	originalSheetId, err := c.findSheetId(table, sheet)
+// Snapshot begins a transaction of the single sheet and returns its
+// snapshot.
+func (c *Client) Snapshot(ctx context.Context, table string, sheet string) (*Snapshot, error) {
+	tx, err := c.Begin(ctx, table, sheet)
 	if err != nil {
 		return nil, err
 	}
+	return tx.Sheet(sheet), nil
+}
 
-	snapshot := &Snapshot{
+func (c *Client) newSnapshot(ctx context.Context, table string, sheet string, sheetId int64) *Snapshot {
+	return &Snapshot{
+		ctx:               ctx,
 		client:            c,
 		table:             table,
 		originalSheetName: sheet,
-		originalSheetId:   originalSheetId,
+		originalSheetId:   sheetId,
 		tempSheetId:       int64(rand.Int31()),
 		tempSheetName:     randString(16),
+		metadataId:        int64(rand.Int31()),
+		created:           time.Now(),
 	}
+}
 
-	err = snapshot.batch(&sheets.Request{
+// createRequests copy the sheet into a hidden one tagged as a snapshot.
+func (s *Snapshot) createRequests() []*sheets.Request {
+	return []*sheets.Request{{
 		DuplicateSheet: &sheets.DuplicateSheetRequest{
-			NewSheetId:    snapshot.tempSheetId,
-			NewSheetName:  snapshot.tempSheetName,
-			SourceSheetId: snapshot.originalSheetId,
+			NewSheetId:    s.tempSheetId,
+			NewSheetName:  s.tempSheetName,
+			SourceSheetId: s.originalSheetId,
 		},
-	}, &sheets.Request{
+	}, {
 		UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
 			Fields: "hidden",
 			Properties: &sheets.SheetProperties{
-				SheetId: snapshot.tempSheetId,
+				SheetId: s.tempSheetId,
 				Hidden:  true,
 			},
 		},
+	}, {
+		CreateDeveloperMetadata: &sheets.CreateDeveloperMetadataRequest{
+			DeveloperMetadata: s.metadata(false),
+		},
+	}}
+}
+
+// WithSnapshot runs cb against a hidden copy of the sheet and commits it
+// back on success, see WithTransaction.
+func (c *Client) WithSnapshot(ctx context.Context, table string, sheet string, cb func(*Snapshot) error) error {
+	return c.WithTransaction(ctx, table, []string{sheet}, func(tx *Transaction) error {
+		return cb(tx.Sheet(sheet))
 	})
+}
+
+func (c *Client) findSheetId(ctx context.Context, table string, sheet string) (int64, error) {
+	sheetIds, err := c.ensureSheets(ctx, table, sheet)
+	if err != nil {
+		return 0, err
+	}
+	return sheetIds[sheet], nil
+}
+
+// sheetProperties returns ids and titles of all tabs in the spreadsheet,
+// in tab order. It is the only place spreadsheet metadata is fetched, see
+// sheetIds and ListSheets.
+func (c *Client) sheetProperties(ctx context.Context, table string) ([]*sheets.SheetProperties, error) {
+	start := time.Now()
+	res, err := c.service.Spreadsheets.Get(table).Fields("sheets.properties(sheetId,title)").Context(ctx).Do()
+	metrics.ObserveRequest(ctx, metrics.ServiceSheets, "get", start, err)
 	if err != nil {
-		log.WithError(err).Errorln("Failed to create sheet snapshot")
 		return nil, err
 	}
 
-	return snapshot, nil
+	properties := make([]*sheets.SheetProperties, 0, len(res.Sheets))
+	for _, sheetRef := range res.Sheets {
+		properties = append(properties, sheetRef.Properties)
+	}
+	return properties, nil
 }
 
-func (c *Client) WithSnapshot(table string, sheet string, cb func(*Snapshot) error) error {
-	snapshot, err := c.Snapshot(table, sheet)
+// sheetIds maps titles of all tabs in the spreadsheet to their ids.
+func (c *Client) sheetIds(ctx context.Context, table string) (map[string]int64, error) {
+	properties, err := c.sheetProperties(ctx, table)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = cb(snapshot)
-
-	if err == nil {
-		return snapshot.Commit()
-	} else {
-		rollbackError := snapshot.Rollback()
-		if rollbackError != nil {
-			log.WithError(rollbackError).Errorln("Rollback failed")
-		}
-		return err
+	sheetIds := make(map[string]int64, len(properties))
+	for _, props := range properties {
+		sheetIds[props.Title] = props.SheetId
 	}
+	return sheetIds, nil
 }
 
-func (c *Client) findSheetId(table string, sheet string) (int64, error) {
-	res, err := c.service.Spreadsheets.Get(table).Fields("sheets").Do()
+// ListSheets returns titles of all tabs in the spreadsheet.
+func (c *Client) ListSheets(ctx context.Context, table string) ([]string, error) {
+	properties, err := c.sheetProperties(ctx, table)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	for _, sheetRef := range res.Sheets {
-		if sheet == sheetRef.Properties.Title {
-			return sheetRef.Properties.SheetId, nil
-		}
+	titles := make([]string, 0, len(properties))
+	for _, props := range properties {
+		titles = append(titles, props.Title)
 	}
-	return 0, errors.New("Unknown sheet")
+	return titles, nil
 }
 
+// Insert and Sort use the id the snapshot was created with, so queries
+// inside a transaction do not fetch spreadsheet metadata again.
 func (s *Snapshot) Insert() *InsertQuery {
-	return s.client.Insert(s.table, s.tempSheetName)
+	return s.client.insert(s.ctx, s.table, s.tempSheetName, s.tempSheetId)
 }
 
 func (s *Snapshot) Delete() *DeleteQuery {
-	return s.client.Delete(s.table, s.tempSheetName)
+	return s.client.Delete(s.ctx, s.table, s.tempSheetName)
 }
 
 func (s *Snapshot) Sort() *SortQuery {
-	return s.client.Sort(s.table, s.tempSheetName)
+	return s.client.sort(s.ctx, s.table, s.tempSheetName, s.tempSheetId)
 }
 
-func (s *Snapshot) Commit() error {
-	return s.batch(&sheets.Request{
+func (s *Snapshot) Upsert() *UpsertQuery {
+	return s.client.Upsert(s.ctx, s.table, s.tempSheetName)
+}
+
+// Commit commits the transaction of the snapshot, see Transaction.Commit.
+func (s *Snapshot) Commit(ctx context.Context) error {
+	return s.tx.Commit(ctx)
+}
+
+// commitRequests replace the original sheet contents with the snapshot
+// and remove the snapshot.
+func (s *Snapshot) commitRequests() []*sheets.Request {
+	return []*sheets.Request{{
 		DeleteRange: &sheets.DeleteRangeRequest{
 			Range: &sheets.GridRange{
 				SheetId: s.originalSheetId,
 			},
 			ShiftDimension: "ROWS",
 		},
-	}, &sheets.Request{
+	}, {
 		CopyPaste: &sheets.CopyPasteRequest{
 			Source: &sheets.GridRange{
 				SheetId: s.tempSheetId,
@@ -503,27 +573,30 @@ func (s *Snapshot) Commit() error {
 				SheetId: s.originalSheetId,
 			},
 		},
-	}, &sheets.Request{
-		DeleteSheet: &sheets.DeleteSheetRequest{
-			SheetId: s.tempSheetId,
-		},
-	})
+	}, s.rollbackRequest()}
 }
 
-func (s *Snapshot) Rollback() error {
-	return s.batch(&sheets.Request{
+// Rollback rolls back the transaction of the snapshot.
+func (s *Snapshot) Rollback(ctx context.Context) error {
+	return s.tx.Rollback(ctx)
+}
+
+func (s *Snapshot) rollbackRequest() *sheets.Request {
+	return &sheets.Request{
 		DeleteSheet: &sheets.DeleteSheetRequest{
 			SheetId: s.tempSheetId,
 		},
-	})
+	}
 }
 
-func (c *Client) batch(table string, requests ...*sheets.Request) error {
+func (c *Client) batch(ctx context.Context, table string, requests ...*sheets.Request) error {
 	req := &sheets.BatchUpdateSpreadsheetRequest{
 		Requests: requests,
 	}
 
-	res, err := c.service.Spreadsheets.BatchUpdate(table, req).Do()
+	start := time.Now()
+	res, err := c.service.Spreadsheets.BatchUpdate(table, req).Context(ctx).Do()
+	metrics.ObserveRequest(ctx, metrics.ServiceSheets, "batchUpdate", start, err)
 	_ = res
 
 	if err != nil {
@@ -533,9 +606,7 @@ func (c *Client) batch(table string, requests ...*sheets.Request) error {
 	return nil
 }
 
-func (s *Snapshot) batch(requests ...*sheets.Request) error {
-	return s.client.batch(s.table, requests...)
-}
+const rollbackTimeout = 30 * time.Second
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
@@ -0,0 +1,80 @@
+package sheets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// fakeService serves spreadsheet metadata with the given tabs, accepts
+// every batchUpdate and counts metadata reads.
+func fakeService(t *testing.T, titles ...string) (*Client, *int32) {
+	t.Helper()
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, ":batchUpdate") {
+			w.Write([]byte(`{}`))
+			return
+		}
+		atomic.AddInt32(&gets, 1)
+		sheetRefs := make([]string, 0, len(titles))
+		for i, title := range titles {
+			sheetRefs = append(sheetRefs, `{"properties":{"sheetId":`+strconv.Itoa(i+1)+`,"title":"`+title+`"}}`)
+		}
+		w.Write([]byte(`{"sheets":[` + strings.Join(sheetRefs, ",") + `]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := sheets.NewService(context.Background(), option.WithEndpoint(server.URL), option.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{service: service}, &gets
+}
+
+func TestTransactionFetchesMetadataOnce(t *testing.T) {
+	client, gets := fakeService(t, "Events", "Reviews")
+	ctx := context.Background()
+
+	tx, err := client.Begin(ctx, "table", "Events", "Reviews")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sheet := range []string{"Events", "Reviews"} {
+		snapshot := tx.Sheet(sheet)
+		if q := snapshot.Insert(); q.err != nil || q.sheetId != snapshot.tempSheetId {
+			t.Errorf("%s: insert into sheet %d (%v), want the snapshot %d", sheet, q.sheetId, q.err, snapshot.tempSheetId)
+		}
+		if q := snapshot.Sort(); q.sheetId == nil || *q.sheetId != snapshot.tempSheetId {
+			t.Errorf("%s: sort of %v, want the snapshot %d", sheet, q.sheetId, snapshot.tempSheetId)
+		}
+	}
+
+	if got := atomic.LoadInt32(gets); got != 1 {
+		t.Errorf("fetched spreadsheet metadata %d times, want once", got)
+	}
+}
+
+func TestListSheets(t *testing.T) {
+	client, gets := fakeService(t, "Reviews", "Events", "Merge Requests")
+
+	titles, err := client.ListSheets(context.Background(), "table")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Reviews", "Events", "Merge Requests"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("got %v, want %v", titles, want)
+	}
+	if got := atomic.LoadInt32(gets); got != 1 {
+		t.Errorf("fetched spreadsheet metadata %d times, want once", got)
+	}
+}
@@ -0,0 +1,246 @@
+package sheets
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// SortKey orders rows by a column. If Order is set, values are ranked by
+// their position in it and values missing from it go after the listed
+// ones. Empty cells always go last, as in the spreadsheet.
+type SortKey struct {
+	Column     string
+	Descending bool
+	Order      []string
+}
+
+func Asc(column string) SortKey {
+	return SortKey{Column: column}
+}
+
+func Desc(column string) SortKey {
+	return SortKey{Column: column, Descending: true}
+}
+
+// ByOrder sorts the column by the position of values in order, e.g. by
+// status severity.
+func ByOrder(column string, order ...string) SortKey {
+	return SortKey{Column: column, Order: order}
+}
+
+func ascending(columns []string) []SortKey {
+	keys := make([]SortKey, len(columns))
+	for i, column := range columns {
+		keys[i] = Asc(column)
+	}
+	return keys
+}
+
+// columnIndices returns the index of every key column among columns.
+func columnIndices(keys []SortKey, columns []string) ([]int, error) {
+	indices := make([]int, len(keys))
+	for i, key := range keys {
+		indices[i] = -1
+		for j, column := range columns {
+			if column == key.Column {
+				indices[i] = j
+				break
+			}
+		}
+		if indices[i] < 0 {
+			return nil, fmt.Errorf("Unknown sort column %q", key.Column)
+		}
+	}
+	return indices, nil
+}
+
+// sortRows sorts rows in place by keys whose columns are at indices.
+func sortRows(rows [][]interface{}, keys []SortKey, indices []int) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		return compareRows(rows[i], rows[j], keys, indices) < 0
+	})
+}
+
+func compareRows(lhs []interface{}, rhs []interface{}, keys []SortKey, indices []int) int {
+	for i, key := range keys {
+		if c := key.compare(valueAt(lhs, indices[i]), valueAt(rhs, indices[i])); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (k SortKey) compare(lhs interface{}, rhs interface{}) int {
+	lhsEmpty, rhsEmpty := DisplayText(lhs) == "", DisplayText(rhs) == ""
+	if lhsEmpty || rhsEmpty {
+		switch {
+		case lhsEmpty && rhsEmpty:
+			return 0
+		case lhsEmpty:
+			return 1
+		default:
+			return -1
+		}
+	}
+
+	c := 0
+	if len(k.Order) > 0 {
+		c = rank(k.Order, lhs) - rank(k.Order, rhs)
+	}
+	if c == 0 {
+		c = compareValues(lhs, rhs)
+	}
+	if k.Descending {
+		return -c
+	}
+	return c
+}
+
+func rank(order []string, value interface{}) int {
+	text := DisplayText(value)
+	for i, item := range order {
+		if item == text {
+			return i
+		}
+	}
+	return len(order)
+}
+
+func valueAt(row []interface{}, index int) interface{} {
+	if index >= len(row) {
+		return nil
+	}
+	return row[index]
+}
+
+// sortRangeRequest sorts rows below the header of the sheet by keys.
+// Custom orders have no equivalent in SortRange, see sortRequests.
+func sortRangeRequest(sheetId int64, keys []SortKey, columns []string) (*sheets.Request, error) {
+	indices, err := columnIndices(keys, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]*sheets.SortSpec, len(keys))
+	for i, key := range keys {
+		if len(key.Order) > 0 {
+			return nil, fmt.Errorf("Custom order of %q cannot be sorted by the spreadsheet", key.Column)
+		}
+		order := "ASCENDING"
+		if key.Descending {
+			order = "DESCENDING"
+		}
+		specs[i] = &sheets.SortSpec{
+			SortOrder:      order,
+			DimensionIndex: int64(indices[i]),
+		}
+	}
+
+	return &sheets.Request{
+		SortRange: &sheets.SortRangeRequest{
+			Range: &sheets.GridRange{
+				SheetId:       sheetId,
+				StartRowIndex: 1,
+			},
+			SortSpecs: specs,
+		},
+	}, nil
+}
+
+// hasCustomOrder tells if some key can only be sorted locally.
+func hasCustomOrder(keys []SortKey) bool {
+	for _, key := range keys {
+		if len(key.Order) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sortRequests returns a request rewriting rows below the header of the
+// grid in the order of keys, or nothing if they are already in order.
+func sortRequests(g *grid, keys []SortKey) ([]*sheets.Request, error) {
+	if len(g.rows) < 2 {
+		return nil, nil
+	}
+
+	columns := make([]string, len(g.rows[0]))
+	for i, cell := range g.rows[0] {
+		columns[i] = DisplayText(cellValue(cell))
+	}
+	indices, err := columnIndices(keys, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	numColumns := 0
+	values := make([][]interface{}, len(g.rows)-1)
+	for i, row := range g.rows[1:] {
+		values[i] = make([]interface{}, len(row))
+		for j, cell := range row {
+			values[i][j] = cellValue(cell)
+		}
+		if len(row) > numColumns {
+			numColumns = len(row)
+		}
+	}
+
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return compareRows(values[order[i]], values[order[j]], keys, indices) < 0
+	})
+	if sort.IntsAreSorted(order) {
+		return nil, nil
+	}
+
+	// Shorter rows are padded, so that cells of the row previously at
+	// their place are cleared.
+	rows := make([]*sheets.RowData, len(order))
+	for i, index := range order {
+		cells := make([]*sheets.CellData, numColumns)
+		for j := range cells {
+			cells[j] = &sheets.CellData{}
+			if cell := g.cell(index+1, j); cell != nil {
+				cells[j] = cell
+			}
+		}
+		rows[i] = &sheets.RowData{Values: cells}
+	}
+
+	return []*sheets.Request{{
+		UpdateCells: &sheets.UpdateCellsRequest{
+			Fields: cellFields,
+			Start: &sheets.GridCoordinate{
+				SheetId:  g.sheetId,
+				RowIndex: 1,
+			},
+			Rows: rows,
+		},
+	}}, nil
+}
+
+// cellValue returns the value of a cell read from the sheet in the form
+// compareValues understands. Formulas are compared by what they display.
+func cellValue(cell *sheets.CellData) interface{} {
+	if cell == nil || cell.UserEnteredValue == nil {
+		return nil
+	}
+	value := cell.UserEnteredValue
+	switch valueKind(value, cell.FormattedValue) {
+	case "formula":
+		return cell.FormattedValue
+	case "string":
+		return value.StringValue
+	case "bool":
+		return value.BoolValue
+	case "number":
+		return value.NumberValue
+	default:
+		return nil
+	}
+}
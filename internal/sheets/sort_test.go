@@ -0,0 +1,172 @@
+package sheets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortedRows(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []SortKey
+		want []string
+	}{
+		{
+			name: "ascending text",
+			keys: []SortKey{Asc("Student")},
+			want: []string{"alice", "bob", "carol", "dave"},
+		},
+		{
+			name: "descending numbers, empty last",
+			keys: []SortKey{Desc("Score")},
+			want: []string{"bob", "carol", "alice", "dave"},
+		},
+		{
+			name: "custom order, unknown values after listed ones",
+			keys: []SortKey{ByOrder("Status", "rejected", "pending"), Asc("Student")},
+			want: []string{"carol", "alice", "dave", "bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := NewTable("Student", "Status", "Score").SortKeys(tt.keys...)
+			table.Append("dave", "pending", nil)
+			table.Append("bob", "approved", 10)
+			table.Append("carol", "rejected", 2)
+			table.Append("alice", "pending", 1.5)
+
+			rows, err := table.SortedRows()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(rows))
+			for i, row := range rows {
+				got[i] = row[0].(string)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortedRowsUnknownColumn(t *testing.T) {
+	table := NewTable("Student").Sort("Task")
+	if _, err := table.SortedRows(); err == nil {
+		t.Fatal("expected an error for an unknown sort column")
+	}
+}
+
+func TestSortRequests(t *testing.T) {
+	keys := []SortKey{ByOrder("Status", "rejected", "pending", "approved")}
+
+	tests := []struct {
+		name    string
+		current *grid
+		want    []string
+	}{
+		{
+			name:    "empty sheet",
+			current: storedGrid([]interface{}{"Student", "Status"}),
+			want:    nil,
+		},
+		{
+			name: "already sorted",
+			current: storedGrid(
+				[]interface{}{"Student", "Status"},
+				[]interface{}{"bob", "rejected"},
+				[]interface{}{"alice", "approved"},
+			),
+			want: nil,
+		},
+		{
+			name: "rows move with their extra cells",
+			current: storedGrid(
+				[]interface{}{"Student", "Status"},
+				[]interface{}{"alice", "approved", "note"},
+				[]interface{}{"bob", "rejected"},
+				[]interface{}{"carol", "pending"},
+			),
+			want: []string{"bob|rejected|", "carol|pending|", "alice|approved|note"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, err := sortRequests(tt.current, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if len(requests) != 0 {
+					t.Fatalf("got %v, want no requests", describe(requests))
+				}
+				return
+			}
+			if len(requests) != 1 || requests[0].UpdateCells == nil {
+				t.Fatalf("got %v, want a single update", describe(requests))
+			}
+			update := requests[0].UpdateCells
+			if update.Start.RowIndex != 1 || update.Start.ColumnIndex != 0 {
+				t.Errorf("update starts at %d:%d, want 1:0", update.Start.RowIndex, update.Start.ColumnIndex)
+			}
+
+			got := make([]string, len(update.Rows))
+			for i, row := range update.Rows {
+				if len(row.Values) != 3 {
+					t.Errorf("row %d has %d cells, want padding to 3", i, len(row.Values))
+				}
+				for j, cell := range row.Values {
+					if j > 0 {
+						got[i] += "|"
+					}
+					got[i] += DisplayText(cellValue(cell))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortRangeRequest(t *testing.T) {
+	columns := []string{"Student", "Task", "Status"}
+
+	tests := []struct {
+		name    string
+		keys    []SortKey
+		want    []int64
+		wantErr bool
+	}{
+		{name: "columns", keys: []SortKey{Asc("Student"), Desc("Task")}, want: []int64{0, 1}},
+		{name: "unknown column", keys: []SortKey{Asc("Score")}, wantErr: true},
+		{name: "custom order", keys: []SortKey{ByOrder("Status", "pending")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := sortRangeRequest(1, tt.keys, columns)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]int64, 0)
+			for _, spec := range request.SortRange.SortSpecs {
+				got = append(got, spec.DimensionIndex)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sorts by columns %v, want %v", got, tt.want)
+			}
+			if request.SortRange.SortSpecs[1].SortOrder != "DESCENDING" {
+				t.Errorf("second key is %s, want DESCENDING", request.SortRange.SortSpecs[1].SortOrder)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package sheets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+)
+
+// snapshotMetadataKey marks sheets created by Client.Snapshot.
+const snapshotMetadataKey = "concurrency_watcher.snapshot"
+
+// snapshotInfo is stored as developer metadata of a snapshot sheet, so
+// that snapshots left behind by a crashed process can be recognized.
+type snapshotInfo struct {
+	Owner   string    `json:"owner"`
+	Sheet   string    `json:"sheet"`
+	Created time.Time `json:"created"`
+	// Complete is set once the snapshot holds the new sheet contents and
+	// is about to be committed.
+	Complete bool `json:"complete"`
+}
+
+// owner identifies this process in snapshot metadata.
+var owner = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}()
+
+func (s *Snapshot) metadata(complete bool) *sheets.DeveloperMetadata {
+	value, _ := json.Marshal(snapshotInfo{
+		Owner:    owner,
+		Sheet:    s.originalSheetName,
+		Created:  s.created,
+		Complete: complete,
+	})
+	return &sheets.DeveloperMetadata{
+		MetadataId:    s.metadataId,
+		MetadataKey:   snapshotMetadataKey,
+		MetadataValue: string(value),
+		Location: &sheets.DeveloperMetadataLocation{
+			SheetId: s.tempSheetId,
+		},
+		Visibility: "DOCUMENT",
+	}
+}
+
+// completeRequest records that the snapshot is about to be committed,
+// which makes it usable for restoring the sheet by SweepSnapshots.
+func (s *Snapshot) completeRequest() *sheets.Request {
+	return &sheets.Request{
+		UpdateDeveloperMetadata: &sheets.UpdateDeveloperMetadataRequest{
+			DataFilters: []*sheets.DataFilter{{
+				DeveloperMetadataLookup: &sheets.DeveloperMetadataLookup{
+					MetadataId: s.metadataId,
+				},
+			}},
+			DeveloperMetadata: &sheets.DeveloperMetadata{
+				MetadataValue: s.metadata(true).MetadataValue,
+			},
+			Fields: "metadataValue",
+		},
+	}
+}
+
+type snapshotSheet struct {
+	sheetId int64
+	title   string
+	info    snapshotInfo
+}
+
+// SweepSnapshots deletes snapshot sheets older than maxAge, which were
+// left behind by processes that died before committing or rolling back.
+// Before that, a sheet that is empty while having a complete snapshot is
+// restored from the newest one, as its commit did not finish.
+func (c *Client) SweepSnapshots(ctx context.Context, table string, maxAge time.Duration) error {
+	logger := logging.Source(ctx, "sheets")
+
+	start := time.Now()
+	res, err := c.service.Spreadsheets.Get(table).Fields("sheets(properties(sheetId,title),developerMetadata)").Context(ctx).Do()
+	metrics.ObserveRequest(ctx, metrics.ServiceSheets, "get", start, err)
+	if err != nil {
+		return err
+	}
+
+	sheetIds := make(map[string]int64)
+	stale := make([]*snapshotSheet, 0)
+	for _, sheetRef := range res.Sheets {
+		sheetIds[sheetRef.Properties.Title] = sheetRef.Properties.SheetId
+		for _, metadata := range sheetRef.DeveloperMetadata {
+			if metadata.MetadataKey != snapshotMetadataKey {
+				continue
+			}
+			snapshot := &snapshotSheet{
+				sheetId: sheetRef.Properties.SheetId,
+				title:   sheetRef.Properties.Title,
+			}
+			if err := json.Unmarshal([]byte(metadata.MetadataValue), &snapshot.info); err != nil {
+				logger.WithError(err).Warnf("Ignoring snapshot %s with malformed metadata", snapshot.title)
+				continue
+			}
+			if time.Since(snapshot.info.Created) >= maxAge {
+				stale = append(stale, snapshot)
+			}
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	newest := make(map[string]*snapshotSheet)
+	for _, snapshot := range stale {
+		prev, found := newest[snapshot.info.Sheet]
+		if snapshot.info.Complete && (!found || snapshot.info.Created.After(prev.info.Created)) {
+			newest[snapshot.info.Sheet] = snapshot
+		}
+	}
+
+	requests := make([]*sheets.Request, 0, len(stale))
+	for _, snapshot := range stale {
+		if newest[snapshot.info.Sheet] != snapshot {
+			continue
+		}
+		sheetId, found := sheetIds[snapshot.info.Sheet]
+		if !found {
+			continue
+		}
+		schema, err := loadSchema(ctx, c, table, snapshot.info.Sheet)
+		if err != nil {
+			return err
+		}
+		if schema != nil {
+			continue
+		}
+
+		logger.Warnf("Restoring empty sheet %s from snapshot %s made by %s at %s", snapshot.info.Sheet, snapshot.title, snapshot.info.Owner, snapshot.info.Created.Format(time.RFC3339))
+		requests = append(requests, &sheets.Request{
+			CopyPaste: &sheets.CopyPasteRequest{
+				Source: &sheets.GridRange{
+					SheetId: snapshot.sheetId,
+				},
+				Destination: &sheets.GridRange{
+					SheetId: sheetId,
+				},
+			},
+		})
+	}
+
+	for _, snapshot := range stale {
+		logger.Infof("Deleting stale snapshot %s of %s made by %s at %s", snapshot.title, snapshot.info.Sheet, snapshot.info.Owner, snapshot.info.Created.Format(time.RFC3339))
+		requests = append(requests, &sheets.Request{
+			DeleteSheet: &sheets.DeleteSheetRequest{
+				SheetId: snapshot.sheetId,
+			},
+		})
+	}
+
+	return c.batch(ctx, table, requests...)
+}
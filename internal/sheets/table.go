@@ -0,0 +1,229 @@
+package sheets
+
+import (
+	"context"
+	"time"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+)
+
+// Table is the desired contents of a generated sheet: a header row, data
+// rows and the keys the rows should be ordered by.
+type Table struct {
+	Columns []string
+	Rows    [][]interface{}
+	SortBy  []SortKey
+	// Key makes writers upsert the table instead of replacing the sheet,
+	// see KeyBy.
+	Key []string
+}
+
+func NewTable(columns ...string) *Table {
+	return &Table{
+		Columns: columns,
+		Rows:    make([][]interface{}, 0),
+	}
+}
+
+func (t *Table) Append(values ...interface{}) *Table {
+	t.Rows = append(t.Rows, values)
+	return t
+}
+
+// Sort orders rows by the columns in ascending order.
+func (t *Table) Sort(columns ...string) *Table {
+	return t.SortKeys(ascending(columns)...)
+}
+
+func (t *Table) SortKeys(keys ...SortKey) *Table {
+	t.SortBy = keys
+	return t
+}
+
+// KeyBy makes writers match rows by the columns, update only the table
+// columns, append new rows and delete rows whose key disappeared, so that
+// columns added to the sheet by hand keep their contents. Rows are then
+// sorted by the spreadsheet, or locally for custom orders.
+func (t *Table) KeyBy(columns ...string) *Table {
+	t.Key = columns
+	return t
+}
+
+// upsert returns the upsert keeping the sheet in sync with the table.
+func (t *Table) upsert() *upsert {
+	return &upsert{
+		keys:          t.Key,
+		fields:        t.Columns,
+		values:        t.Rows,
+		appendNew:     true,
+		deleteMissing: true,
+	}
+}
+
+// SortedRows returns rows ordered by SortBy the same way the spreadsheet
+// orders them. It fails if a key refers to an unknown column.
+func (t *Table) SortedRows() ([][]interface{}, error) {
+	indices, err := columnIndices(t.SortBy, t.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := append([][]interface{}(nil), t.Rows...)
+	sortRows(rows, t.SortBy, indices)
+	return rows, nil
+}
+
+// Writer stores generated tables. The Google Sheets implementation is
+// SpreadsheetWriter; dry runs use a local renderer instead.
+type Writer interface {
+	WriteTable(ctx context.Context, sheet string, table *Table) error
+	AppendTable(ctx context.Context, sheet string, table *Table) error
+}
+
+// Reader fetches the current contents of a sheet as displayed text.
+type Reader interface {
+	ReadTable(ctx context.Context, sheet string) (*Table, error)
+}
+
+// Update modes of SpreadsheetWriter.WriteTable.
+const (
+	// UpdateDiff sends only changed cells, see Client.WriteDiff.
+	UpdateDiff = "diff"
+	// UpdateSnapshot clears and rewrites the sheet inside a snapshot.
+	UpdateSnapshot = "snapshot"
+)
+
+type SpreadsheetWriter struct {
+	client *Client
+	table  string
+	mode   string
+}
+
+func (c *Client) Spreadsheet(table string) *SpreadsheetWriter {
+	return &SpreadsheetWriter{
+		client: c,
+		table:  table,
+		mode:   UpdateDiff,
+	}
+}
+
+// UpdateMode selects how WriteTable updates sheets.
+func (w *SpreadsheetWriter) UpdateMode(mode string) *SpreadsheetWriter {
+	w.mode = mode
+	return w
+}
+
+// WriteTable replaces the sheet contents with the table.
+func (w *SpreadsheetWriter) WriteTable(ctx context.Context, sheet string, table *Table) error {
+	if w.mode == UpdateSnapshot {
+		return w.writeSnapshot(ctx, sheet, table)
+	}
+	return w.client.WriteDiff(ctx, w.table, sheet, table)
+}
+
+// NamedTable is a table together with the sheet it is written to.
+type NamedTable struct {
+	Sheet string
+	Table *Table
+}
+
+// WriteTables replaces contents of several sheets at once: either every
+// sheet is updated or none is.
+func (w *SpreadsheetWriter) WriteTables(ctx context.Context, tables []NamedTable) error {
+	if len(tables) == 0 {
+		return nil
+	}
+	if w.mode != UpdateSnapshot {
+		return w.client.WriteDiffs(ctx, w.table, tables)
+	}
+
+	sheetNames := make([]string, len(tables))
+	for i, named := range tables {
+		sheetNames[i] = named.Sheet
+	}
+	return w.client.WithTransaction(ctx, w.table, sheetNames, func(tx *Transaction) error {
+		for _, named := range tables {
+			if err := fillSnapshot(ctx, tx.Sheet(named.Sheet), named.Table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (w *SpreadsheetWriter) writeSnapshot(ctx context.Context, sheet string, table *Table) error {
+	return w.client.WithSnapshot(ctx, w.table, sheet, func(snapshot *Snapshot) error {
+		return fillSnapshot(ctx, snapshot, table)
+	})
+}
+
+func fillSnapshot(ctx context.Context, snapshot *Snapshot, table *Table) error {
+	if len(table.Key) > 0 {
+		query := snapshot.Upsert().Key(table.Key...).Into(table.Columns...).Rows(table.Rows).DeleteMissing()
+		if err := query.Do(); err != nil {
+			logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to upsert rows into the table")
+			return err
+		}
+		if len(table.SortBy) > 0 {
+			if err := snapshot.Sort().Keys(table.SortBy...).Do(); err != nil {
+				logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to sort table")
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := snapshot.Delete().Do(); err != nil {
+		logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to clear table")
+		return err
+	}
+
+	// Rows are sorted before the insert, which saves a SortRange request.
+	if err := snapshot.Insert().Into(table.Columns...).Rows(table.Rows).SortBy(table.SortBy...).Do(); err != nil {
+		logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to append rows to the table")
+		return err
+	}
+
+	return nil
+}
+
+// AppendTable appends rows below the existing contents without clearing
+// the sheet. Columns missing from the header are added to it.
+func (w *SpreadsheetWriter) AppendTable(ctx context.Context, sheet string, table *Table) error {
+	return w.client.Insert(ctx, w.table, sheet).Into(table.Columns...).Rows(table.Rows).Do()
+}
+
+// SweepSnapshots removes snapshot sheets older than maxAge, see
+// Client.SweepSnapshots.
+func (w *SpreadsheetWriter) SweepSnapshots(ctx context.Context, maxAge time.Duration) error {
+	return w.client.SweepSnapshots(ctx, w.table, maxAge)
+}
+
+// Rotate moves rows above maxRows into the archive sheet.
+func (w *SpreadsheetWriter) Rotate(ctx context.Context, sheet string, archive string, maxRows int) error {
+	return w.client.Rotate(ctx, w.table, sheet, archive, maxRows)
+}
+
+// ReadTable returns the formatted values of the sheet. The first row is
+// treated as the header.
+func (w *SpreadsheetWriter) ReadTable(ctx context.Context, sheet string) (*Table, error) {
+	start := time.Now()
+	res, err := w.client.service.Spreadsheets.Values.Get(w.table, sheet).ValueRenderOption("FORMATTED_VALUE").Context(ctx).Do()
+	metrics.ObserveRequest(ctx, metrics.ServiceSheets, "values.get", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	table := NewTable()
+	for i, row := range res.Values {
+		if i == 0 {
+			for _, value := range row {
+				table.Columns = append(table.Columns, DisplayText(value))
+			}
+			continue
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, nil
+}
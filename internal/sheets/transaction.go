@@ -0,0 +1,115 @@
+package sheets
+
+import (
+	"context"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+)
+
+// Transaction snapshots several sheets of a spreadsheet. Queries run
+// against the snapshots, and Commit replaces every original sheet in a
+// single batchUpdate, so either all sheets are updated or none is.
+type Transaction struct {
+	client    *Client
+	table     string
+	snapshots []*Snapshot
+}
+
+// Begin creates snapshots of the sheets in a single batchUpdate.
+func (c *Client) Begin(ctx context.Context, table string, sheetNames ...string) (*Transaction, error) {
+	sheetIds, err := c.ensureSheets(ctx, table, sheetNames...)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &Transaction{
+		client: c,
+		table:  table,
+	}
+	requests := make([]*sheets.Request, 0)
+	for _, sheet := range sheetNames {
+		snapshot := c.newSnapshot(ctx, table, sheet, sheetIds[sheet])
+		snapshot.tx = tx
+		tx.snapshots = append(tx.snapshots, snapshot)
+		requests = append(requests, snapshot.createRequests()...)
+	}
+
+	if err := c.batch(ctx, table, requests...); err != nil {
+		logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to create sheet snapshots")
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Sheet returns the snapshot of the sheet, or nil if the transaction does
+// not include it.
+func (tx *Transaction) Sheet(sheet string) *Snapshot {
+	for _, snapshot := range tx.snapshots {
+		if snapshot.originalSheetName == sheet {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+// Commit marks the snapshots complete and then copies all of them into
+// the original sheets in one batchUpdate.
+func (tx *Transaction) Commit(ctx context.Context) error {
+	completed := make([]*sheets.Request, 0, len(tx.snapshots))
+	requests := make([]*sheets.Request, 0, 3*len(tx.snapshots))
+	for _, snapshot := range tx.snapshots {
+		completed = append(completed, snapshot.completeRequest())
+		requests = append(requests, snapshot.commitRequests()...)
+	}
+
+	if err := tx.client.batch(ctx, tx.table, completed...); err != nil {
+		logging.Source(ctx, "sheets").WithError(err).Errorln("Failed to mark snapshots complete")
+		return err
+	}
+	if err := tx.client.batch(ctx, tx.table, requests...); err != nil {
+		return err
+	}
+
+	for _, snapshot := range tx.snapshots {
+		metrics.SheetCommitted(ctx, snapshot.originalSheetName)
+	}
+	return nil
+}
+
+// Rollback deletes all snapshots, leaving the original sheets unchanged.
+func (tx *Transaction) Rollback(ctx context.Context) error {
+	requests := make([]*sheets.Request, 0, len(tx.snapshots))
+	for _, snapshot := range tx.snapshots {
+		requests = append(requests, snapshot.rollbackRequest())
+	}
+	return tx.client.batch(ctx, tx.table, requests...)
+}
+
+// WithTransaction runs cb against snapshots of the sheets and commits all
+// of them on success. Rollback does not use ctx, so the temporary sheets
+// are removed even if the failure was caused by ctx cancellation.
+func (c *Client) WithTransaction(ctx context.Context, table string, sheetNames []string, cb func(*Transaction) error) error {
+	tx, err := c.Begin(ctx, table, sheetNames...)
+	if err != nil {
+		return err
+	}
+
+	err = cb(tx)
+	if err == nil {
+		err = tx.Commit(ctx)
+		if err == nil {
+			return nil
+		}
+	}
+
+	rollbackCtx, cancel := context.WithTimeout(metrics.WithCourse(context.Background(), metrics.CourseFromContext(ctx)), rollbackTimeout)
+	defer cancel()
+
+	if rollbackError := tx.Rollback(rollbackCtx); rollbackError != nil {
+		logging.Source(ctx, "sheets").WithError(rollbackError).Errorln("Rollback failed")
+	}
+	return err
+}
@@ -0,0 +1,364 @@
+package sheets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+
+	"github.com/bigredeye/concurrency_watcher/internal/logging"
+	"github.com/bigredeye/concurrency_watcher/internal/metrics"
+)
+
+// UpsertQuery matches rows by key columns. Matched rows get their managed
+// columns, the ones passed to Into, updated; rows with new keys are
+// appended. Other columns are never touched, so columns added by hand
+// keep their contents. Keys are compared by value, the same way cells are
+// compared by WriteDiff, and must be unique among the values.
+type UpsertQuery struct {
+	upsert
+
+	ctx    context.Context
+	client *Client
+	table  string
+	sheet  string
+}
+
+// UpdateQuery only updates rows whose keys are already in the sheet, values
+// with new keys are skipped. It never appends or deletes rows.
+type UpdateQuery struct {
+	q *UpsertQuery
+}
+
+type upsert struct {
+	keys          []string
+	fields        []string
+	values        [][]interface{}
+	appendNew     bool
+	deleteMissing bool
+}
+
+func (c *Client) Upsert(ctx context.Context, table string, sheet string) *UpsertQuery {
+	return &UpsertQuery{
+		upsert: upsert{
+			values:    make([][]interface{}, 0),
+			appendNew: true,
+		},
+		ctx:    ctx,
+		client: c,
+		table:  table,
+		sheet:  sheet,
+	}
+}
+
+func (c *Client) Update(ctx context.Context, table string, sheet string) *UpdateQuery {
+	q := c.Upsert(ctx, table, sheet)
+	q.appendNew = false
+	return &UpdateQuery{q: q}
+}
+
+// Key sets the columns identifying a row, they must be among the fields.
+func (q *UpsertQuery) Key(columns ...string) *UpsertQuery {
+	q.keys = columns
+	return q
+}
+
+func (q *UpsertQuery) Into(fields ...string) *UpsertQuery {
+	q.fields = fields
+	return q
+}
+
+func (q *UpsertQuery) Values(values ...interface{}) *UpsertQuery {
+	q.values = append(q.values, values)
+	return q
+}
+
+func (q *UpsertQuery) Rows(rows [][]interface{}) *UpsertQuery {
+	q.values = append(q.values, rows...)
+	return q
+}
+
+// DeleteMissing also deletes rows whose key is not among the values.
+func (q *UpsertQuery) DeleteMissing() *UpsertQuery {
+	q.deleteMissing = true
+	return q
+}
+
+func (q *UpsertQuery) Do() error {
+	grids, err := q.client.readGrids(q.ctx, q.table, q.sheet)
+	if err != nil {
+		return err
+	}
+
+	requests, _, err := q.requests(grids[q.sheet])
+	if err != nil {
+		return err
+	}
+	if len(requests) > 0 {
+		if err := q.client.batch(q.ctx, q.table, requests...); err != nil {
+			logging.Source(q.ctx, "sheets").WithError(err).Errorf("Failed to upsert into %s", q.sheet)
+			return err
+		}
+	}
+	metrics.SheetCommitted(q.ctx, q.sheet)
+	return nil
+}
+
+// Key sets the columns identifying a row, they must be among the fields.
+func (q *UpdateQuery) Key(columns ...string) *UpdateQuery {
+	q.q.Key(columns...)
+	return q
+}
+
+func (q *UpdateQuery) Into(fields ...string) *UpdateQuery {
+	q.q.Into(fields...)
+	return q
+}
+
+func (q *UpdateQuery) Values(values ...interface{}) *UpdateQuery {
+	q.q.Values(values...)
+	return q
+}
+
+func (q *UpdateQuery) Rows(rows [][]interface{}) *UpdateQuery {
+	q.q.Rows(rows)
+	return q
+}
+
+func (q *UpdateQuery) Do() error {
+	return q.q.Do()
+}
+
+// requests returns requests applying the upsert to the sheet and the
+// header the sheet ends up with. Updates come first, as they refer to
+// current row indices, and deletions go bottom up.
+func (u *upsert) requests(g *grid) ([]*sheets.Request, []string, error) {
+	if len(u.keys) == 0 {
+		return nil, nil, errors.New("No key columns")
+	}
+	keyIndices := make([]int, len(u.keys))
+	for i, key := range u.keys {
+		keyIndices[i] = -1
+		for j, field := range u.fields {
+			if field == key {
+				keyIndices[i] = j
+			}
+		}
+		if keyIndices[i] < 0 {
+			return nil, nil, fmt.Errorf("Key column %q is not among the fields", key)
+		}
+	}
+	for _, row := range u.values {
+		if len(row) != len(u.fields) {
+			return nil, nil, errors.New("Mismatched numbers of values and fields")
+		}
+	}
+
+	header := make([]string, 0)
+	if len(g.rows) > 0 {
+		for _, cell := range g.rows[0] {
+			header = append(header, DisplayText(cellValue(cell)))
+		}
+	}
+	for len(header) > 0 && header[len(header)-1] == "" {
+		header = header[:len(header)-1]
+	}
+
+	mapping := newMappingFromFields(header...)
+	columns := make([]int, len(u.fields))
+	added := make([]int, 0)
+	for i, field := range u.fields {
+		index, found := mapping.columnToIndex[field]
+		if !found {
+			index = mapping.add(field)
+			header = append(header, field)
+			added = append(added, index)
+		}
+		columns[i] = index
+	}
+
+	requests := make([]*sheets.Request, 0)
+	if g.rowCount == 0 {
+		requests = append(requests, appendDimension(g.sheetId, "ROWS", 1))
+	}
+	if extra := int64(len(header)) - g.columnCount; extra > 0 {
+		requests = append(requests, appendDimension(g.sheetId, "COLUMNS", extra))
+	}
+	for _, index := range added {
+		requests = append(requests, updateCell(g.sheetId, 0, index, formatCellData(header[index])))
+	}
+
+	existing := make([]keyedRow, 0)
+	for row := 1; row < len(g.rows); row++ {
+		key := rowKey(len(keyIndices), func(i int) *sheets.CellData {
+			return g.cell(row, columns[keyIndices[i]])
+		})
+		if key != nil {
+			existing = append(existing, keyedRow{row: row, key: key})
+		}
+	}
+
+	wanted := make([][]*sheets.CellData, 0)
+	appended := make([]*sheets.RowData, 0)
+	for _, values := range u.values {
+		key := rowKey(len(keyIndices), func(i int) *sheets.CellData {
+			return formatCellData(values[keyIndices[i]])
+		})
+		if key != nil {
+			if containsKey(wanted, key) {
+				return nil, nil, fmt.Errorf("Duplicate key %s", keyText(key))
+			}
+			wanted = append(wanted, key)
+		}
+
+		rows := matchingRows(existing, key)
+		if len(rows) == 0 {
+			if !u.appendNew {
+				continue
+			}
+			cells := make([]*sheets.CellData, len(header))
+			for i := range cells {
+				cells[i] = &sheets.CellData{}
+			}
+			for i, value := range values {
+				cells[columns[i]] = formatCellData(value)
+			}
+			appended = append(appended, &sheets.RowData{Values: cells})
+			continue
+		}
+
+		for _, row := range rows {
+			for i, value := range values {
+				want := formatCellData(value)
+				if sameCell(g.cell(row, columns[i]), want) {
+					continue
+				}
+				if sameCell(want, nil) {
+					want = &sheets.CellData{}
+				}
+				requests = append(requests, updateCell(g.sheetId, row, columns[i], want))
+			}
+		}
+	}
+
+	if len(appended) > 0 {
+		requests = append(requests, &sheets.Request{
+			AppendCells: &sheets.AppendCellsRequest{
+				Fields:  cellFields,
+				SheetId: g.sheetId,
+				Rows:    appended,
+			},
+		})
+	}
+
+	if u.deleteMissing {
+		missing := make([]int, 0)
+		for _, row := range existing {
+			if !containsKey(wanted, row.key) {
+				missing = append(missing, row.row)
+			}
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(missing)))
+		for _, row := range missing {
+			requests = append(requests, &sheets.Request{
+				DeleteDimension: &sheets.DeleteDimensionRequest{
+					Range: &sheets.DimensionRange{
+						SheetId:    g.sheetId,
+						Dimension:  "ROWS",
+						StartIndex: int64(row),
+						EndIndex:   int64(row + 1),
+					},
+				},
+			})
+		}
+	}
+
+	return requests, header, nil
+}
+
+// keyedRow is a row of the sheet with the cells of its key columns.
+type keyedRow struct {
+	row int
+	key []*sheets.CellData
+}
+
+// rowKey returns the cells of n key columns. Rows with all key cells empty
+// have no key.
+func rowKey(n int, cell func(i int) *sheets.CellData) []*sheets.CellData {
+	key := make([]*sheets.CellData, n)
+	empty := true
+	for i := range key {
+		key[i] = cell(i)
+		if key[i] == nil {
+			key[i] = &sheets.CellData{}
+		}
+		if !sameValue(key[i], &sheets.CellData{}) {
+			empty = false
+		}
+	}
+	if empty {
+		return nil
+	}
+	return key
+}
+
+func sameKey(lhs []*sheets.CellData, rhs []*sheets.CellData) bool {
+	if lhs == nil || rhs == nil || len(lhs) != len(rhs) {
+		return false
+	}
+	for i := range lhs {
+		if !sameValue(lhs[i], rhs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsKey(keys [][]*sheets.CellData, key []*sheets.CellData) bool {
+	for _, other := range keys {
+		if sameKey(other, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingRows returns indices of the rows with the key.
+func matchingRows(rows []keyedRow, key []*sheets.CellData) []int {
+	res := make([]int, 0)
+	for _, row := range rows {
+		if sameKey(row.key, key) {
+			res = append(res, row.row)
+		}
+	}
+	return res
+}
+
+func keyText(key []*sheets.CellData) string {
+	parts := make([]string, len(key))
+	for i, cell := range key {
+		text := DisplayText(cellValue(cell))
+		if value := cell.UserEnteredValue; value != nil && value.FormulaValue != "" {
+			text = value.FormulaValue
+		}
+		parts[i] = fmt.Sprintf("%q", text)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func updateCell(sheetId int64, row int, column int, cell *sheets.CellData) *sheets.Request {
+	return &sheets.Request{
+		UpdateCells: &sheets.UpdateCellsRequest{
+			Fields: cellFields,
+			Start: &sheets.GridCoordinate{
+				SheetId:     sheetId,
+				RowIndex:    int64(row),
+				ColumnIndex: int64(column),
+			},
+			Rows: []*sheets.RowData{{Values: []*sheets.CellData{cell}}},
+		},
+	}
+}
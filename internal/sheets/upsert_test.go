@@ -0,0 +1,257 @@
+package sheets
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// stored returns the cell as Sheets returns it: zero values are omitted
+// and the formatted value is filled in.
+func stored(value interface{}) *sheets.CellData {
+	cell := formatCellData(value)
+	cell.UserEnteredValue.ForceSendFields = nil
+	cell.FormattedValue = DisplayText(value)
+	return cell
+}
+
+func storedGrid(rows ...[]interface{}) *grid {
+	g := &grid{sheetId: 7, rowCount: 1000, columnCount: 26}
+	for _, row := range rows {
+		cells := make([]*sheets.CellData, len(row))
+		for i, value := range row {
+			cells[i] = stored(value)
+		}
+		g.rows = append(g.rows, cells)
+	}
+	return g
+}
+
+func TestUpsertRequests(t *testing.T) {
+	week := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	current := storedGrid(
+		[]interface{}{"Week", "Reviews", "Comment"},
+		[]interface{}{week, 3, "added by hand"},
+		[]interface{}{week.AddDate(0, 0, 7), 5},
+	)
+
+	tests := []struct {
+		name          string
+		fields        []string
+		values        [][]interface{}
+		appendNew     bool
+		deleteMissing bool
+		want          []string
+		wantErr       bool
+	}{
+		{
+			name:      "unchanged date keys",
+			fields:    []string{"Week", "Reviews"},
+			values:    [][]interface{}{{week, 3}, {week.AddDate(0, 0, 7), 5}},
+			appendNew: true,
+			want:      []string{},
+		},
+		{
+			name:      "changed value",
+			fields:    []string{"Week", "Reviews"},
+			values:    [][]interface{}{{week, 4}, {week.AddDate(0, 0, 7), 5}},
+			appendNew: true,
+			want:      []string{"updateCells"},
+		},
+		{
+			name:      "new key",
+			fields:    []string{"Week", "Reviews"},
+			values:    [][]interface{}{{week, 3}, {week.AddDate(0, 0, 7), 5}, {week.AddDate(0, 0, 14), 0}},
+			appendNew: true,
+			want:      []string{"appendCells"},
+		},
+		{
+			name:   "new key without append",
+			fields: []string{"Week", "Reviews"},
+			values: [][]interface{}{{week.AddDate(0, 0, 14), 1}},
+			want:   []string{},
+		},
+		{
+			name:          "missing key",
+			fields:        []string{"Week", "Reviews"},
+			values:        [][]interface{}{{week.AddDate(0, 0, 7), 5}},
+			appendNew:     true,
+			deleteMissing: true,
+			want:          []string{"deleteDimension"},
+		},
+		{
+			name:      "new column",
+			fields:    []string{"Week", "Reviews", "Approvals"},
+			values:    [][]interface{}{{week, 3, 1}, {week.AddDate(0, 0, 7), 5, nil}},
+			appendNew: true,
+			want:      []string{"updateCells", "updateCells"},
+		},
+		{
+			name:      "duplicate key",
+			fields:    []string{"Week", "Reviews"},
+			values:    [][]interface{}{{week, 3}, {week, 4}},
+			appendNew: true,
+			wantErr:   true,
+		},
+		{
+			name:    "key not among fields",
+			fields:  []string{"Reviews"},
+			values:  [][]interface{}{{3}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &upsert{
+				keys:          []string{"Week"},
+				fields:        tt.fields,
+				values:        tt.values,
+				appendNew:     tt.appendNew,
+				deleteMissing: tt.deleteMissing,
+			}
+			requests, _, err := u.requests(current)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(requests))
+			for i, request := range requests {
+				got[i] = requestKind(request)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpsertKeepsOtherColumns(t *testing.T) {
+	current := storedGrid(
+		[]interface{}{"Student", "Score", "Comment"},
+		[]interface{}{"alice", 1, "late"},
+		[]interface{}{"bob", 2},
+	)
+	u := &upsert{
+		keys:      []string{"Student"},
+		fields:    []string{"Student", "Score"},
+		values:    [][]interface{}{{"bob", 3}, {"alice", 1}},
+		appendNew: true,
+	}
+
+	requests, header, err := u.requests(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Student", "Score", "Comment"}; !reflect.DeepEqual(header, want) {
+		t.Errorf("header is %v, want %v", header, want)
+	}
+	if len(requests) != 1 || requests[0].UpdateCells == nil {
+		t.Fatalf("got %d requests, want a single update", len(requests))
+	}
+	start := requests[0].UpdateCells.Start
+	if start.RowIndex != 2 || start.ColumnIndex != 1 {
+		t.Errorf("updates cell %d:%d, want 2:1", start.RowIndex, start.ColumnIndex)
+	}
+}
+
+func TestKeyedTableCustomOrder(t *testing.T) {
+	current := storedGrid(
+		[]interface{}{"Student", "Status", "Comment"},
+		[]interface{}{"alice", "approved", "note"},
+		[]interface{}{"bob", "rejected"},
+	)
+
+	tests := []struct {
+		name  string
+		rows  [][]interface{}
+		want  []string
+		order []string
+	}{
+		{
+			name:  "already in order",
+			rows:  [][]interface{}{{"alice", "approved"}, {"bob", "rejected"}},
+			want:  []string{},
+			order: []string{"alice", "bob"},
+		},
+		{
+			name:  "reordered by a changed value",
+			rows:  [][]interface{}{{"alice", "rejected"}, {"bob", "pending"}},
+			want:  []string{"updateCells", "updateCells", "updateCells"},
+			order: []string{"bob", "alice"},
+		},
+		{
+			name:  "appended row goes first",
+			rows:  [][]interface{}{{"alice", "approved"}, {"bob", "rejected"}, {"carol", "pending"}},
+			want:  []string{"appendCells", "updateCells"},
+			order: []string{"carol", "alice", "bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := NewTable("Student", "Status").KeyBy("Student").SortKeys(ByOrder("Status", "pending", "approved", "rejected"))
+			for _, row := range tt.rows {
+				table.Append(row...)
+			}
+
+			requests, err := tableRequests(current, table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(requests))
+			for i, request := range requests {
+				got[i] = requestKind(request)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+
+			after := current.apply(requests)
+			students := make([]string, 0)
+			for _, row := range after.rows[1:] {
+				students = append(students, DisplayText(cellValue(row[0])))
+			}
+			if !reflect.DeepEqual(students, tt.order) {
+				t.Errorf("rows are %v, want %v", students, tt.order)
+			}
+			// Hand-added columns move along with their rows.
+			for i, student := range students {
+				if student == "alice" && DisplayText(cellValue(after.cell(1+i, 2))) != "note" {
+					t.Errorf("alice lost the comment")
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateDoesNotAppend(t *testing.T) {
+	current := storedGrid(
+		[]interface{}{"Student", "Score"},
+		[]interface{}{"alice", 1},
+	)
+	q := (&Client{}).Update(context.Background(), "table", "sheet").
+		Key("Student").
+		Into("Student", "Score").
+		Rows([][]interface{}{{"alice", 2}, {"bob", 3}})
+
+	requests, _, err := q.q.requests(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, len(requests))
+	for i, request := range requests {
+		got[i] = requestKind(request)
+	}
+	if want := []string{"updateCells"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -18,14 +18,16 @@ type Pagination struct {
 }
 
 type MergeRequest struct {
-	Title        string               `json:"title"`
-	Author       User                 `json:"author"`
-	CreatedAt    string               `json:"createdAt"`
-	MergeStatus  string               `json:"mergeStatus"`
-	ApprovedBy   UserCollection       `json:"approvedBy"`
-	HeadPipeline Pipeline             `json:"headPipeline"`
-	WebUrl       string               `json:"webUrl"`
-	Discussions  DiscussionCollection `json:"discussions"`
+	Title           string               `json:"title"`
+	Author          User                 `json:"author"`
+	CreatedAt       string               `json:"createdAt"`
+	MergeStatus     string               `json:"mergeStatus"`
+	Conflicts       bool                 `json:"conflicts"`
+	ShouldBeRebased bool                 `json:"shouldBeRebased"`
+	ApprovedBy      UserCollection       `json:"approvedBy"`
+	HeadPipeline    Pipeline             `json:"headPipeline"`
+	WebUrl          string               `json:"webUrl"`
+	Discussions     DiscussionCollection `json:"discussions"`
 }
 
 type User struct {
@@ -48,4 +50,18 @@ type DiscussionCollection struct {
 type Discussion struct {
 	Resolved   bool
 	Resolvable bool
+	ResolvedAt string         `json:"resolvedAt"`
+	ResolvedBy *User          `json:"resolvedBy"`
+	Notes      NoteCollection `json:"notes"`
+}
+
+type NoteCollection struct {
+	Nodes []*Note `json:"nodes"`
+}
+
+type Note struct {
+	Author    User   `json:"author"`
+	CreatedAt string `json:"createdAt"`
+	System    bool   `json:"system"`
+	Body      string `json:"body"`
 }